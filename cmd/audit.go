@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"os"
+)
+
+// 发现问题且未全部修复时返回exitError, 便于定时任务告警
+func runAudit(args []string) int {
+	fs := newFlagSet("audit")
+	repair := fs.Bool("repair", false, "修复可自动修复的问题")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	err := db.OpenMySQL(conf.MysqlDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	issues, err := db.Audit(conf.Chain, *repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	for _, issue := range issues {
+		fmt.Printf("%-22s kid=%s owner=%s %s\n", issue.Kind, issue.Kid, issue.Owner, issue.Detail)
+	}
+	fmt.Printf("%d issues\n", len(issues))
+
+	for _, issue := range issues {
+		//缺少代币信息无法自动修复
+		if !*repair || issue.Kind == db.IssueMissingToken {
+			return exitError
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"holders/scanner"
+	"os"
+)
+
+// 游标命令只需要LevelDB, 需在扫描进程停止后运行, 扫描进程运行时LevelDB被锁定, 命令报错退出
+func runCursor(args []string) int {
+	fs := newFlagSet("cursor")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	err := db.OpenLevelDB(conf.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	fmt.Println(db.FistNumber(conf.Chain))
+	return exitOK
+}
+
+func runSetCursor(args []string) int {
+	fs := newFlagSet("set-cursor")
+	height := fs.Int64("height", -1, "新的扫描游标, 下次从height+1开始扫描")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *height < 0 {
+		fmt.Fprintln(os.Stderr, "-height is required")
+		fs.Usage()
+		return exitUsage
+	}
+
+	err := db.OpenLevelDB(conf.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	//已写入数据库的高度不会重复写入, 游标回退只会重新拉取事件
+	err = db.WriteNumber(conf.Chain, fmt.Sprint(*height))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Println(*height)
+	return exitOK
+}
+
+func runReindex(args []string) int {
+	fs := newFlagSet("reindex")
+	from := fs.Int64("from", 0, "从该高度开始重新扫描, 默认为链配置的起始高度+1, 指定-kid时默认为合约首次出现的高度")
+	kid := fs.String("kid", "", "只重建该合约, 扫描进程启动后在后台补齐, 主扫描不受影响")
+	yes := fs.Bool("yes", false, "确认删除全部余额和持有数据")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *kid != "" {
+		return reindexToken(*kid, *from, *yes)
+	}
+	if *from == 0 {
+		*from = conf.ProfileOf(conf.Chain).StartNumber + 1
+	}
+	if *from < 1 {
+		fmt.Fprintln(os.Stderr, "-from must be positive")
+		return exitUsage
+	}
+	if !*yes {
+		fmt.Fprintln(os.Stderr, "reindex drops all balance and holding tables, pass -yes to confirm")
+		return exitUsage
+	}
+
+	err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	err = db.ResetState(conf.Chain, *from-1)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Printf("state cleared, %s will be rescanned from %d\n", conf.Chain, *from)
+	return exitOK
+}
+
+// 登记单合约重建任务, 需在扫描进程停止后运行
+func reindexToken(kid string, from int64, yes bool) int {
+	if from < 0 {
+		fmt.Fprintln(os.Stderr, "-from must not be negative")
+		return exitUsage
+	}
+	if !yes {
+		fmt.Fprintf(os.Stderr, "reindex drops the balance and holding data of %s, pass -yes to confirm\n", kid)
+		return exitUsage
+	}
+
+	err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	from, err = scanner.ScheduleReindex(conf.Chain, kid, from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Printf("%s will be rebuilt from %d when the scanner starts\n", kid, from)
+	return exitOK
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"holders/ledger"
+	"holders/scanner"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// 可重复的字符串参数
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// 逗号分隔的字符串列表参数
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = nil
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// 通过-profile修改或新增的链配置
+var profileFlags stringsFlag
+
+// 所有命令通用的参数, 直接写入conf
+func commonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conf.Chain, "chain", conf.Chain, "默认的链, 配置名称或链标识, 作为游标和队列的键")
+	fs.Var((*listFlag)(&conf.Chains), "chains", "同时扫描和提供接口的链, 逗号分隔的配置名称或链标识")
+	fs.Var(&profileFlags, "profile", "修改或新增链配置, 格式为 \"name,chain=ID,node=URL,ws=URL,start=N,zero=ADDR,ns=PREFIX,kids=K1@N|K2,ignore-kids=K3,kips=B20,ignore-kips=B721\", 列表用|分隔, 可重复")
+	fs.StringVar(&conf.NodeUrl, "node", conf.NodeUrl, "节点地址")
+	fs.StringVar(&conf.NodeToken, "node-token", conf.NodeToken, "节点Bearer token, 也可通过环境变量HOLDERS_NODE_TOKEN传入")
+	fs.StringVar(&conf.NodeBasicAuth, "node-basic-auth", conf.NodeBasicAuth, "节点Basic auth, 格式为 user:password, 也可通过环境变量HOLDERS_NODE_BASIC_AUTH传入")
+	fs.Var((*stringsFlag)(&conf.NodeHeaders), "node-header", "请求节点时附加的请求头, 格式为 \"Name: value\", 可重复")
+	fs.StringVar(&conf.NodeProxy, "node-proxy", conf.NodeProxy, "访问节点的HTTP代理")
+	fs.StringVar(&conf.NodeCAFile, "node-ca", conf.NodeCAFile, "额外信任的CA证书文件")
+	fs.StringVar(&conf.NodeCertFile, "node-cert", conf.NodeCertFile, "双向TLS客户端证书文件")
+	fs.StringVar(&conf.NodeKeyFile, "node-key", conf.NodeKeyFile, "双向TLS客户端私钥文件")
+	fs.StringVar(&conf.NodeRecord, "node-record", conf.NodeRecord, "将节点请求和响应录制到该文件")
+	fs.StringVar(&conf.NodeReplay, "node-replay", conf.NodeReplay, "从录制文件回放节点响应, 不访问节点")
+	fs.StringVar(&conf.MysqlDSN, "dsn", conf.MysqlDSN, "MySQL连接串")
+	fs.StringVar(&conf.DataDir, "data", conf.DataDir, "LevelDB数据目录")
+	fs.StringVar(&conf.OverdraftPolicy, "overdraft", conf.OverdraftPolicy, "发送地址余额不足时的处理策略: reject, clamp 或 negative")
+	fs.StringVar(&conf.UnknownSenderPolicy, "unknown-sender", conf.UnknownSenderPolicy, "发送地址没有余额记录时的处理策略: reject, clamp 或 negative")
+	fs.Float64Var(&conf.RPCRate, "rpc-rate", conf.RPCRate, "节点请求每秒上限, 0表示不限制")
+	fs.IntVar(&conf.RPCBurst, "rpc-burst", conf.RPCBurst, "节点请求令牌桶容量")
+	fs.IntVar(&conf.RPCMaxInFlight, "rpc-max-inflight", conf.RPCMaxInFlight, "同时进行的最大节点请求数, 0表示不限制")
+	fs.StringVar(&conf.RPCMethodLimits, "rpc-method-limits", conf.RPCMethodLimits, "单个方法的限制, 如 getEvents=20:40:8,getTokenUri=5")
+	fs.StringVar(&conf.LogLevel, "log-level", conf.LogLevel, "日志级别: debug, info, warn, error")
+	fs.StringVar(&conf.LogFormat, "log-format", conf.LogFormat, "日志格式: text 或 json")
+}
+
+// 扫描相关参数
+func scanFlags(fs *flag.FlagSet) {
+	fs.IntVar(&conf.Workers, "workers", conf.Workers, "事件解析并发数")
+	fs.IntVar(&conf.ResolveWindow, "window", conf.ResolveWindow, "同时处理中的最大区块数量")
+	fs.StringVar(&conf.FailPolicy, "fail-policy", conf.FailPolicy, "区块写入失败策略: retry 或 quarantine")
+	fs.IntVar(&conf.MaxRetries, "max-retries", conf.MaxRetries, "quarantine策略下隔离前的重试次数")
+	confirmationsFlag(fs)
+	fs.BoolVar(&conf.Pending, "pending", conf.Pending, "维护未确认区块的视图, 接口可通过state=latest查询")
+	fs.DurationVar(&conf.PollInterval, "poll-interval", conf.PollInterval, "追上最新高度后的最短轮询间隔")
+	fs.DurationVar(&conf.PollMaxInterval, "poll-max-interval", conf.PollMaxInterval, "没有新区块时的最长轮询间隔")
+	fs.DurationVar(&conf.BackoffMaxInterval, "backoff-max-interval", conf.BackoffMaxInterval, "节点出错时的最长重试间隔")
+	fs.StringVar(&conf.NodeWS, "node-ws", conf.NodeWS, "节点新区块推送的websocket地址, 为空时只轮询")
+	fs.StringVar(&conf.NodeWSSubscribe, "node-ws-subscribe", conf.NodeWSSubscribe, "连接websocket后发送的订阅消息")
+	fs.Var((*listFlag)(&conf.Kids), "kids", "默认链只索引这些合约, 逗号分隔, 每一项为 kid 或 kid@起始高度, 新加入的合约在启动时自动补齐")
+	fs.Var((*listFlag)(&conf.IgnoreKids), "ignore-kids", "默认链不索引的合约, 逗号分隔")
+	fs.Var((*listFlag)(&conf.Kips), "kips", "默认链只索引这些协议, 逗号分隔, 如 B20,B721")
+	fs.Var((*listFlag)(&conf.IgnoreKips), "ignore-kips", "默认链不索引的协议, 逗号分隔")
+	fs.StringVar(&conf.MetricsListen, "metrics", conf.MetricsListen, "只扫描时/metrics的监听地址")
+}
+
+// 确认数, 扫描时决定写入的高度, 接口服务据此计算落后的区块数, 两者分开运行时需设置相同的值
+func confirmationsFlag(fs *flag.FlagSet) {
+	fs.Int64Var(&conf.Confirmations, "confirmations", conf.Confirmations, "确认数, 只写入不高于 最新高度-确认数 的区块")
+}
+
+// 接口服务参数
+func serveFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conf.Listen, "listen", conf.Listen, "接口服务监听地址")
+	fs.Int64Var(&conf.ReadyMaxLag, "ready-max-lag", conf.ReadyMaxLag, "落后节点超过该区块数时/readyz返回失败")
+}
+
+// 常驻进程参数
+func shutdownFlags(fs *flag.FlagSet) {
+	fs.StringVar(&conf.TraceExporter, "trace-exporter", conf.TraceExporter, "trace导出方式: stdout, file, otlp, 为空时不导出")
+	fs.StringVar(&conf.TraceTarget, "trace-target", conf.TraceTarget, "trace导出目标: file时为文件路径, otlp时为地址")
+	fs.DurationVar(&conf.ShutdownTimeout, "shutdown-timeout", conf.ShutdownTimeout, "退出时的最长等待时间")
+}
+
+// 按参数设置节点请求限制
+func setLimits() error {
+	if conf.RPCRate < 0 || conf.RPCBurst < 0 || conf.RPCMaxInFlight < 0 {
+		return errors.New("invalid rpc limits")
+	}
+	methods, err := jsonrpc.ParseLimits(conf.RPCMethodLimits)
+	if err != nil {
+		return err
+	}
+	jsonrpc.SetLimits(jsonrpc.Limit{
+		Rate:        conf.RPCRate,
+		Burst:       conf.RPCBurst,
+		MaxInFlight: conf.RPCMaxInFlight,
+	}, methods)
+	return nil
+}
+
+// 按参数设置节点访问配置
+func setNodeOptions() error {
+	o := jsonrpc.Options{
+		BearerToken: conf.NodeToken,
+		Proxy:       conf.NodeProxy,
+		CAFile:      conf.NodeCAFile,
+		CertFile:    conf.NodeCertFile,
+		KeyFile:     conf.NodeKeyFile,
+		Record:      conf.NodeRecord,
+		Replay:      conf.NodeReplay,
+	}
+	if conf.NodeBasicAuth != "" {
+		user, password, ok := strings.Cut(conf.NodeBasicAuth, ":")
+		if !ok {
+			return errors.New("invalid basic auth, expected user:password")
+		}
+		o.Username, o.Password = user, password
+	}
+	if len(conf.NodeHeaders) > 0 {
+		o.Headers = make(map[string]string)
+		for _, h := range conf.NodeHeaders {
+			name, value, err := jsonrpc.ParseHeader(h)
+			if err != nil {
+				return err
+			}
+			o.Headers[name] = value
+		}
+	}
+	err := jsonrpc.SetDefaultOptions(o)
+	if err != nil {
+		return err
+	}
+	slog.Debug("node options", "node", jsonrpc.RedactURL(conf.NodeUrl), "options", o)
+	return nil
+}
+
+// 按参数设置余额不足和没有余额记录时的处理策略
+func setOverdraftPolicy() error {
+	policy, err := ledger.ParsePolicy(conf.OverdraftPolicy)
+	if err != nil {
+		return err
+	}
+	unknown, err := ledger.ParsePolicy(conf.UnknownSenderPolicy)
+	if err != nil {
+		return err
+	}
+	db.SetOverdraftPolicy(policy)
+	db.SetUnknownSenderPolicy(unknown)
+	return nil
+}
+
+// 解析-profile参数, 修改已有配置或新增配置. 新增配置默认以名称作为链标识, 以"名称_"作为表前缀
+func parseProfile(v string) error {
+	fields := strings.Split(v, ",")
+	name := strings.TrimSpace(fields[0])
+	if name == "" || strings.Contains(name, "=") {
+		return fmt.Errorf("invalid profile %q, expected name,key=value,...", v)
+	}
+	p := conf.GetProfile(name)
+	if p == nil {
+		p = &conf.Profile{Name: name, Chain: name, StartNumber: conf.StartNumber, ZeroAddress: conf.ZeroAddress, Namespace: name + "_"}
+		conf.Profiles = append(conf.Profiles, p)
+	}
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return fmt.Errorf("invalid profile field %q", field)
+		}
+		switch key {
+		case "chain":
+			p.Chain = value
+		case "node":
+			p.NodeUrl = value
+		case "ws":
+			p.NodeWS = value
+		case "start":
+			start, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid profile start %q", value)
+			}
+			p.StartNumber = start
+		case "zero":
+			p.ZeroAddress = value
+		case "ns":
+			p.Namespace = value
+		case "kids":
+			p.Kids = splitList(value)
+		case "ignore-kids":
+			p.IgnoreKids = splitList(value)
+		case "kips":
+			p.Kips = splitList(value)
+		case "ignore-kips":
+			p.IgnoreKips = splitList(value)
+		default:
+			return fmt.Errorf("unknown profile field %q", key)
+		}
+	}
+	return nil
+}
+
+// -profile中用|分隔的列表
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 按参数设置链配置: 应用-profile, 将-chain和-chains解析为链标识,
+// 显式指定的-node, -node-ws和索引范围参数作用于默认的链
+func setProfiles(fs *flag.FlagSet) error {
+	for _, v := range profileFlags {
+		err := parseProfile(v)
+		if err != nil {
+			return err
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	conf.Chain = conf.ProfileOf(conf.Chain).Chain
+	if p := conf.GetProfile(conf.Chain); p != nil {
+		if set["node"] {
+			p.NodeUrl = conf.NodeUrl
+		}
+		if set["node-ws"] {
+			p.NodeWS = conf.NodeWS
+		}
+		if set["kids"] {
+			p.Kids = conf.Kids
+		}
+		if set["ignore-kids"] {
+			p.IgnoreKids = conf.IgnoreKids
+		}
+		if set["kips"] {
+			p.Kips = conf.Kips
+		}
+		if set["ignore-kips"] {
+			p.IgnoreKips = conf.IgnoreKips
+		}
+	}
+
+	var chains []string
+	seen := make(map[string]bool)
+	for _, name := range conf.Chains {
+		chain := conf.ProfileOf(name).Chain
+		if !seen[chain] {
+			seen[chain] = true
+			chains = append(chains, chain)
+		}
+	}
+	if len(chains) > 0 && !seen[conf.Chain] {
+		if set["chain"] {
+			return fmt.Errorf("chain %s is not in -chains", conf.Chain)
+		}
+		conf.Chain = chains[0]
+	}
+	conf.Chains = chains
+
+	namespaces := make(map[string]string)
+	for _, chain := range conf.ActiveChains() {
+		ns := conf.ProfileOf(chain).Namespace
+		if other, ok := namespaces[ns]; ok {
+			return fmt.Errorf("chains %s and %s share namespace %q", other, chain, ns)
+		}
+		namespaces[ns] = chain
+	}
+	return nil
+}
+
+// 校验每条链的索引范围, 扫描时按链配置生成
+func checkFilters() error {
+	for _, chain := range conf.ActiveChains() {
+		p := conf.ProfileOf(chain)
+		_, err := scanner.ParseFilter(p.Kids, p.IgnoreKids, p.Kips, p.IgnoreKips)
+		if err != nil {
+			return fmt.Errorf("%s: %w", chain, err)
+		}
+	}
+	return nil
+}
@@ -1,24 +1,122 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"holders/conf"
-	"holders/scanner"
-	api "holders/service"
+	"holders/logger"
+	"os"
 )
 
+// 退出码
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"run", "扫描并提供接口服务(默认)", runAll},
+	{"serve", "只提供接口服务", runServe},
+	{"scan", "只扫描区块", runScan},
+	{"reindex", "清空索引数据并从指定高度重新扫描", runReindex},
+	{"rewind", "按流水将索引数据回退到指定高度", runRewind},
+	{"cursor", "查看扫描游标", runCursor},
+	{"set-cursor", "设置扫描游标", runSetCursor},
+	{"status", "查看索引进度", runStatus},
+	{"audit", "检查并修复余额与持有数据", runAudit},
+	{"reconcile", "与链上余额对账", runReconcile},
+}
+
 func main() {
-	client, err := scanner.NewClient(conf.NodeUrl, "btc-mainNet")
-	if err != nil {
-		fmt.Println(err)
+	name := "run"
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name = args[0]
+		args = args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		os.Exit(exitOK)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			os.Exit(cmd.run(args))
+		}
 	}
 
-	//扫描日志
-	go client.FilterLogs()
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(exitUsage)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: holders <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `run "holders <command> -h" for command flags`)
+}
 
-	//解析日志
-	go client.ResolveLogs()
+// 创建子命令的参数集, 带上所有命令通用的参数
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	commonFlags(fs)
+	return fs
+}
 
-	service := api.NewGinService()
-	service.Run(":8085")
+// 解析参数, 失败时返回退出码
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if err == flag.ErrHelp {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		return exitUsage, false
+	}
+	err = logger.Setup(conf.LogLevel, conf.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	err = setProfiles(fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	err = setLimits()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	err = setNodeOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	err = setOverdraftPolicy()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	err = checkFilters()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage, false
+	}
+	return exitOK, true
 }
@@ -160,7 +160,7 @@ func TestT20(t *testing.T) {
 		From:   "to1",
 		To:     "to2",
 	}
-	db.Transaction20(t20)
+	db.Transaction20(conf.Chain, t20)
 }
 
 func TestT721(t *testing.T) {
@@ -170,7 +170,7 @@ func TestT721(t *testing.T) {
 		To:      "to1",
 		TokenId: "10000",
 	}
-	db.Transaction721(transfer721)
+	db.Transaction721(conf.Chain, transfer721)
 }
 
 func TestToken(t *testing.T) {
@@ -178,7 +178,7 @@ func TestToken(t *testing.T) {
 }
 
 func getTokenMeta(kid string) {
-	exits := db.GetTokenExits(kid)
+	exits := db.GetTokenExits(conf.Chain, kid)
 	fmt.Println(exits)
 	if !exits {
 		//获取对应信息并保存
@@ -206,16 +206,16 @@ func getTokenMeta(kid string) {
 			TotalSupply: t.TotalSupply,
 		}
 
-		err = db.Token(t2)
+		err = db.Token(conf.Chain, t2)
 		if err != nil {
 			return
 		}
-		db.PutTokenExits(kid)
+		db.PutTokenExits(conf.Chain, kid)
 	}
 }
 
 func TestHold(t *testing.T) {
-	holds, err := db.FindWalletHold("2N7TYrDKNeZf4eVGXDVJyRKWaPdbx4qvCJj")
+	holds, err := db.FindWalletHold(conf.Chain, "2N7TYrDKNeZf4eVGXDVJyRKWaPdbx4qvCJj")
 
 	if err != nil {
 		fmt.Println(err)
@@ -224,7 +224,7 @@ func TestHold(t *testing.T) {
 }
 
 func TestTokenIds(t *testing.T) {
-	tokenIds, err := db.FindTokenIds("kfc1715339bf254ee12fb03da6ba1099cd831e9d2b", "wallet1")
+	tokenIds, err := db.FindTokenIds(conf.Chain, "kfc1715339bf254ee12fb03da6ba1099cd831e9d2b", "wallet1")
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -233,7 +233,7 @@ func TestTokenIds(t *testing.T) {
 
 
 func TestDist(t *testing.T) {
-	dist, err := db.FindDist("kfc1715339bf254ee12fb03da6ba1099cd831e9d2b", false)
+	dist, err := db.FindDist(conf.Chain, "kfc1715339bf254ee12fb03da6ba1099cd831e9d2b", false)
 	if err != nil {
 		fmt.Println(err)
 	}
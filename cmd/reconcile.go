@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"holders/scanner"
+	"os"
+)
+
+// 存在不一致时返回exitError
+func runReconcile(args []string) int {
+	fs := newFlagSet("reconcile")
+	kid := fs.String("kid", "", "要对账的合约地址")
+	sample := fs.Int("sample", 100, "抽样数量, 0为检查全部持有人")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *kid == "" {
+		fmt.Fprintln(os.Stderr, "-kid is required")
+		return exitUsage
+	}
+
+	//只读取MySQL和节点, 扫描进程运行时也可使用
+	err := db.OpenMySQL(conf.MysqlDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	report, err := scanner.Reconcile(conf.Chain, *kid, *sample)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	data, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(data))
+
+	if len(report.Mismatches) > 0 {
+		return exitError
+	}
+	return exitOK
+}
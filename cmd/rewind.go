@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"os"
+	"strings"
+)
+
+// 回退需要独占LevelDB, 扫描进程运行时会打开失败, 需先停止扫描进程
+func runRewind(args []string) int {
+	fs := newFlagSet("rewind")
+	to := fs.Int64("to", -1, "回退到该高度, 撤销之后写入的余额, 持有和代币数据")
+	yes := fs.Bool("yes", false, "确认回退")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *to < 0 {
+		fmt.Fprintln(os.Stderr, "-to is required")
+		fs.Usage()
+		return exitUsage
+	}
+	if !*yes {
+		fmt.Fprintln(os.Stderr, "rewind reverts all changes above the target height, pass -yes to confirm")
+		return exitUsage
+	}
+
+	err := openDB()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	result, err := db.Rewind(conf.Chain, *to)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	fmt.Printf("%s rewound from %d to %d, %d journal entries reverted\n", conf.Chain, result.From, result.To, result.Journal)
+	if len(result.Tokens) > 0 {
+		fmt.Printf("tokens removed: %s\n", strings.Join(result.Tokens, ", "))
+	}
+	return exitOK
+}
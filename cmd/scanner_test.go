@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"holders/conf"
 	"holders/db"
+	"holders/mocknode"
 	"holders/scanner"
+	"os"
 	api "holders/service"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	err := openDB()
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
 func TestNumber(t *testing.T) {
 	err := db.WriteNumber("btc-testNet","2810930")
 	if err != nil {
@@ -23,10 +35,10 @@ func TestScanner(t *testing.T) {
 	}
 
 	//扫描日志
-	go client.FilterLogs()
+	go client.FilterLogs(context.Background())
 
 	//解析日志
-	go client.ResolveLogs()
+	go client.ResolveLogs(context.Background())
 
 	service := api.NewGinService()
 	service.Run(":8085")
@@ -41,4 +53,82 @@ func TestService(t *testing.T) {
 func TestServer(t *testing.T) {
 	service := api.NewGinService()
 	service.Run(":8085")
-}
\ No newline at end of file
+}
+
+// 使用模拟节点端到端扫描, 需要MySQL
+func TestScanMockNode(t *testing.T) {
+	node := mocknode.New(conf.StartNumber)
+	defer node.Close()
+	kid := fmt.Sprintf("bitmock%d", time.Now().UnixNano())
+	node.SetScript(kid, "B20", nil)
+	node.SetToken(kid, mocknode.Token{Name: "Mock", Symbol: "MCK", TotalSupply: 100})
+	node.AddEvents(mocknode.Transfer20(kid, conf.ZeroAddress, "alice", 100))
+	node.AddEvents(mocknode.Transfer20(kid, "alice", "bob", 30))
+
+	chain := "mock-" + kid
+	client, err := scanner.NewClient(node.URL(), chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 2)
+	go func() {
+		client.FilterLogs(ctx)
+		done <- struct{}{}
+	}()
+	go func() {
+		client.ResolveLogs(ctx)
+		done <- struct{}{}
+	}()
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		applied, err := db.AppliedNumber(chain)
+		if err == nil && applied >= node.Best() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("applied = %d, want %d", applied, node.Best())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	<-done
+
+	dist, err := db.FindDist(chain, kid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"alice": "70", "bob": "30"}
+	if len(dist) != len(want) {
+		t.Fatalf("dist = %+v, want %v", dist, want)
+	}
+	for _, d := range dist {
+		if want[d.Owner] != d.Amount {
+			t.Errorf("balance of %s = %s, want %s", d.Owner, d.Amount, want[d.Owner])
+		}
+	}
+
+	//流水按时间倒序
+	journal, err := db.FindJournal(chain, "alice", kid, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal) != 2 || journal[0].Delta != -30 || journal[0].Balance != 70 || journal[1].Delta != 100 {
+		t.Fatalf("journal = %+v", journal)
+	}
+
+	//撤销第二个区块
+	err = db.UndoHeights(chain, node.Best()-1, node.Best())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dist, err = db.FindDist(chain, kid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dist) != 1 || dist[0].Owner != "alice" || dist[0].Amount != "100" {
+		t.Fatalf("dist after undo = %+v", dist)
+	}
+}
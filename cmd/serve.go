@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"holders/conf"
+	"holders/db"
+	"holders/lifecycle"
+	"holders/scanner"
+	"holders/tracing"
+	api "holders/service"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+func runAll(args []string) int {
+	fs := newFlagSet("run")
+	scanFlags(fs)
+	serveFlags(fs)
+	shutdownFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	return start(true, true)
+}
+
+func runServe(args []string) int {
+	fs := newFlagSet("serve")
+	serveFlags(fs)
+	confirmationsFlag(fs)
+	shutdownFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	return start(false, true)
+}
+
+func runScan(args []string) int {
+	fs := newFlagSet("scan")
+	scanFlags(fs)
+	shutdownFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	return start(true, false)
+}
+
+// 打开数据库
+func openDB() error {
+	err := db.OpenLevelDB(conf.DataDir)
+	if err != nil {
+		return err
+	}
+	err = db.OpenMySQL(conf.MysqlDSN)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+// 启动扫描和/或接口服务, 直到收到退出信号
+func start(scan, serve bool) int {
+	if conf.FailPolicy != conf.FailRetry && conf.FailPolicy != conf.FailQuarantine {
+		fmt.Fprintf(os.Stderr, "invalid fail policy %q\n", conf.FailPolicy)
+		return exitUsage
+	}
+
+	if conf.PollInterval <= 0 || conf.PollMaxInterval < conf.PollInterval || conf.BackoffMaxInterval < conf.PollInterval {
+		fmt.Fprintln(os.Stderr, "invalid poll intervals")
+		return exitUsage
+	}
+	if conf.Confirmations < 0 {
+		fmt.Fprintf(os.Stderr, "invalid confirmations %d\n", conf.Confirmations)
+		return exitUsage
+	}
+
+	//接口服务只读MySQL, 不占用LevelDB, 可以与扫描进程同时运行
+	var err error
+	if scan {
+		err = openDB()
+	} else {
+		err = db.OpenMySQL(conf.MysqlDSN)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), conf.TraceExporter, conf.TraceTarget)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		db.Close()
+		return exitUsage
+	}
+
+	m := lifecycle.New(conf.ShutdownTimeout)
+	//最后关闭数据库
+	m.OnStop("db", func(ctx context.Context) error {
+		return db.Close()
+	})
+	//导出剩余的span
+	m.OnStop("tracing", shutdownTracing)
+
+	if scan {
+		for _, chain := range conf.ActiveChains() {
+			profile := conf.ProfileOf(chain)
+			if profile.NodeUrl == "" {
+				fmt.Fprintf(os.Stderr, "no node url for chain %s, set it with -profile %s,node=URL\n", chain, profile.Name)
+				db.Close()
+				return exitUsage
+			}
+			client, err := scanner.NewClient(profile.NodeUrl, chain)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				db.Close()
+				return exitError
+			}
+
+			//扫描日志
+			m.Go("filter "+chain, client.FilterLogs)
+
+			//解析日志
+			m.Go("resolve "+chain, client.ResolveLogs)
+		}
+	}
+
+	var failed atomic.Bool
+	if serve {
+		service := api.NewGinService()
+		m.OnStop("api", service.Shutdown)
+		go func() {
+			err := service.Run(conf.Listen)
+			if err != nil {
+				slog.Error("api server failed", "err", err)
+				failed.Store(true)
+				m.Stop()
+			}
+		}()
+	}
+
+	if scan && !serve && conf.MetricsListen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		server := &http.Server{Addr: conf.MetricsListen, Handler: mux}
+		m.OnStop("metrics", server.Shutdown)
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("metrics server failed", "err", err)
+				failed.Store(true)
+				m.Stop()
+			}
+		}()
+	}
+
+	m.Wait()
+	if failed.Load() {
+		return exitError
+	}
+	return exitOK
+}
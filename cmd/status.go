@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"os"
+)
+
+type status struct {
+	Chain   string `json:"chain"`
+	Applied int64  `json:"applied"`
+	Best    int64  `json:"best"`
+	Lag     int64  `json:"lag"`
+}
+
+// 查看已写入高度和节点高度. 只读取MySQL, 扫描进程运行时也可使用,
+// 扫描游标和队列长度在LevelDB中, 由运行中的进程的 /status 接口提供
+func runStatus(args []string) int {
+	fs := newFlagSet("status")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	err := db.OpenMySQL(conf.MysqlDSN)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	defer db.Close()
+
+	s := status{Chain: conf.Chain}
+	s.Applied, err = db.AppliedNumber(conf.Chain)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	code := exitOK
+	cli, err := jsonrpc.NewClient(conf.ProfileOf(conf.Chain).NodeUrl)
+	if err == nil {
+		var best any
+		best, err = cli.BestBlockNumber()
+		if err == nil {
+			s.Best = best.(int64)
+			s.Lag = s.Best - s.Applied
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		code = exitError
+	}
+
+	data, _ := json.MarshalIndent(s, "", "  ")
+	fmt.Println(string(data))
+	return code
+}
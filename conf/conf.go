@@ -1,8 +1,203 @@
 package conf
 
-const NodeUrl = "https://mainnet.brc20pm.com"
+import (
+	"os"
+	"time"
+)
+
+var NodeUrl = "https://mainnet.brc20pm.com"
+
+// 节点鉴权, 密钥也可以通过环境变量传入, 避免出现在进程参数中
+var NodeToken = os.Getenv("HOLDERS_NODE_TOKEN")
+
+// 节点Basic auth, 格式为 user:password
+var NodeBasicAuth = os.Getenv("HOLDERS_NODE_BASIC_AUTH")
+
+// 请求节点时附加的请求头, 格式为 "Name: value"
+var NodeHeaders []string
+
+// 访问节点的HTTP代理, 为空时使用环境变量 HTTP_PROXY/HTTPS_PROXY
+var NodeProxy = ""
+
+// 额外信任的CA证书, 以及双向TLS的客户端证书和私钥
+var (
+	NodeCAFile   = ""
+	NodeCertFile = ""
+	NodeKeyFile  = ""
+)
+
+// 将节点请求和响应录制到该文件
+var NodeRecord = ""
+
+// 从录制文件回放节点响应, 用于离线重建和测试
+var NodeReplay = ""
+
+// 参考 https://github.com/go-sql-driver/mysql#dsn-data-source-name 获取详情
+var MysqlDSN = "root:lisp000724@tcp(127.0.0.1:3306)/bits_scanner?charset=utf8mb4&parseTime=True&loc=Local"
+
+// LevelDB数据目录
+var DataDir = "data"
+
+// 接口服务监听地址
+var Listen = ":8085"
+
+// 只扫描时暴露/metrics的监听地址, 为空时不启动
+var MetricsListen = ""
+
+// 已写入高度落后节点超过该区块数时/readyz返回失败
+var ReadyMaxLag int64 = 10
+
+// 日志级别 debug, info, warn, error
+var LogLevel = "info"
+
+// 日志格式 text 或 json
+var LogFormat = "text"
+
+// trace导出方式 stdout, file, otlp, 为空时不导出
+var TraceExporter = ""
+
+// trace导出目标, file时为文件路径, otlp时为地址
+var TraceTarget = ""
+
+// 默认的链, 接口路径中没有指定链以及只处理一条链的命令使用该链
+var Chain = "btc-mainNet"
+
+// 同时扫描和提供接口的链, 可以是配置名称或链标识, 为空时只处理Chain
+var Chains []string
 
 const StartNumber = 853023
 
 // 黑洞地址
 const ZeroAddress = "ord000000000000000000000000000000000000000"
+
+// 链配置, 每条链有独立的节点, 起始高度, 黑洞地址和数据表前缀
+type Profile struct {
+	// 配置名称, 如mainnet
+	Name string `json:"name"`
+	// 链标识, 作为游标, 队列和流水的键
+	Chain string `json:"chain"`
+	// 节点地址, 可能包含鉴权信息, 不输出
+	NodeUrl string `json:"-"`
+	// 新区块推送的websocket地址, 为空时只轮询
+	NodeWS string `json:"-"`
+	// 协议运行区块 - 1, 从下一个高度开始扫描
+	StartNumber int64  `json:"startNumber"`
+	ZeroAddress string `json:"zeroAddress"`
+	// 数据表和缓存键的前缀, 为空时使用不带前缀的表名, 兼容单链时的数据
+	Namespace string `json:"namespace"`
+	// 只索引这些合约, 每一项为 kid 或 kid@起始高度, 为空时索引全部合约
+	Kids []string `json:"kids,omitempty"`
+	// 不索引的合约
+	IgnoreKids []string `json:"ignoreKids,omitempty"`
+	// 只索引这些协议, 如B20, B721, 为空时索引全部协议
+	Kips []string `json:"kips,omitempty"`
+	// 不索引的协议
+	IgnoreKips []string `json:"ignoreKips,omitempty"`
+}
+
+// 内置的链配置, 节点地址为空时需通过参数指定
+var Profiles = []*Profile{
+	{Name: "mainnet", Chain: "btc-mainNet", NodeUrl: NodeUrl, StartNumber: StartNumber, ZeroAddress: ZeroAddress},
+	{Name: "testnet", Chain: "btc-testNet", StartNumber: 2810930, ZeroAddress: ZeroAddress, Namespace: "tn_"},
+	{Name: "regtest", Chain: "btc-regtest", ZeroAddress: ZeroAddress, Namespace: "rt_"},
+}
+
+// 按配置名称或链标识查找链配置
+func GetProfile(name string) *Profile {
+	for _, p := range Profiles {
+		if p.Name == name || p.Chain == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// 正在处理的链标识
+func ActiveChains() []string {
+	if len(Chains) == 0 {
+		return []string{Chain}
+	}
+	return Chains
+}
+
+// 链配置, 没有配置的链使用默认的节点, 起始高度和黑洞地址, 不加表前缀
+func ProfileOf(chain string) *Profile {
+	if p := GetProfile(chain); p != nil {
+		return p
+	}
+	return &Profile{Name: chain, Chain: chain, NodeUrl: NodeUrl, NodeWS: NodeWS, StartNumber: StartNumber, ZeroAddress: ZeroAddress,
+		Kids: Kids, IgnoreKids: IgnoreKids, Kips: Kips, IgnoreKips: IgnoreKips}
+}
+
+// 默认链的索引范围, 其他链在链配置中设置. 只索引这些合约, 每一项为 kid 或 kid@起始高度, 为空时索引全部合约
+var Kids []string
+
+// 默认链不索引的合约
+var IgnoreKids []string
+
+// 默认链只索引这些协议, 如B20, B721, 为空时索引全部协议
+var Kips []string
+
+// 默认链不索引的协议
+var IgnoreKips []string
+
+// 事件处理worker数量, 同一合约的事件总由同一个worker按顺序处理
+var Workers = 4
+
+// 同时处理中的最大区块数量
+var ResolveWindow = 64
+
+// 区块写入失败时的处理策略
+const (
+	// 一直重试直到成功
+	FailRetry = "retry"
+	// 重试MaxRetries次后移入隔离区并跳过该区块
+	FailQuarantine = "quarantine"
+)
+
+var FailPolicy = FailRetry
+
+var MaxRetries = 5
+
+// 发送地址余额不足时的处理策略:
+// reject 跳过该转账并记录异常, 区块中的其他转账照常写入; clamp 发送地址余额扣到0为止; negative 允许负余额
+var OverdraftPolicy = "clamp"
+
+// 发送地址没有余额记录时的处理策略, 取值同OverdraftPolicy. 配置了起始高度的合约 (kid@height) 缺少之前的余额, reject 按 clamp 处理
+var UnknownSenderPolicy = "reject"
+
+// 确认数, 只写入不高于 最新高度 - Confirmations 的区块, 0表示写入到最新高度
+var Confirmations int64 = 0
+
+// 是否在内存中维护未确认区块的视图, 供接口查询latest状态
+var Pending = false
+
+// 追上最新高度后的最短轮询间隔, 也是出错重试的初始间隔
+var PollInterval = 2 * time.Second
+
+// 没有新区块时轮询间隔逐渐增加到该值, 已连接推送时按该间隔兜底轮询
+var PollMaxInterval = time.Minute
+
+// 请求节点出错时重试间隔的上限
+var BackoffMaxInterval = time.Minute
+
+// 节点新区块推送的websocket地址, 为空时只轮询
+var NodeWS = ""
+
+// 连接websocket后发送的订阅消息, 为空时不发送
+var NodeWSSubscribe = ""
+
+// 所有节点请求共享的每秒请求数, 0表示不限制
+var RPCRate float64 = 0
+
+// 令牌桶容量, 0时取 max(1, RPCRate)
+var RPCBurst = 0
+
+// 同时进行的最大节点请求数, 0表示不限制
+var RPCMaxInFlight = 16
+
+// 单个方法的额外限制, 格式为 method=rate[:burst[:inflight]], 多个之间用逗号分隔
+var RPCMethodLimits = ""
+
+// 退出时等待扫描任务和接口请求完成的最长时间
+var ShutdownTimeout = 30 * time.Second
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+	"holders/ledger"
+	"holders/metrics"
+	"holders/models"
+)
+
+// 发送地址余额不足和没有余额记录时的处理策略, 由启动参数设置
+var overdraft, unknownSender ledger.Policy
+
+// 设置发送地址余额不足时的处理策略
+func SetOverdraftPolicy(policy ledger.Policy) {
+	overdraft = policy
+}
+
+// 设置发送地址没有余额记录时的处理策略
+func SetUnknownSenderPolicy(policy ledger.Policy) {
+	unknownSender = policy
+}
+
+// 从指定高度开始索引的合约, 键为链和合约. 起始高度之前的余额没有写入,
+// 发送地址没有余额记录是正常情况, 按clamp处理并记录异常, 而不是拒绝转账
+var partialKids = make(map[string]map[string]bool)
+var partialMutex sync.RWMutex
+
+// 设置链上从指定高度开始索引的合约
+func SetPartialKids(chain string, kids []string) {
+	set := make(map[string]bool)
+	for _, kid := range kids {
+		set[kid] = true
+	}
+	partialMutex.Lock()
+	partialKids[chain] = set
+	partialMutex.Unlock()
+}
+
+func isPartialKid(chain, kid string) bool {
+	partialMutex.RLock()
+	defer partialMutex.RUnlock()
+	return partialKids[chain][kid]
+}
+
+// 在事务中记录转账异常, rejected表示转账已按reject策略跳过
+func recordAnomalies(tx *gorm.DB, chain string, t interface{}, anomalies []ledger.Anomaly, rejected bool) error {
+	for _, a := range anomalies {
+		anomaly := models.Anomaly{
+			Chain:    chain,
+			Kid:      a.Kid,
+			Owner:    a.Owner,
+			Kind:     a.Kind,
+			Policy:   string(a.Policy),
+			Balance:  a.Balance,
+			Amount:   a.Amount,
+			Rejected: rejected,
+		}
+		switch transfer := t.(type) {
+		case models.Transfer20:
+			anomaly.Height, anomaly.TxHash, anomaly.EHash = transfer.Height, transfer.TxHash, transfer.EHash
+		case models.Transfer721:
+			anomaly.Height, anomaly.TxHash, anomaly.EHash = transfer.Height, transfer.TxHash, transfer.EHash
+		}
+		err := tx.Create(&anomaly).Error
+		if err != nil {
+			return err
+		}
+		transferLog(chain, anomaly.Height, t).Warn("transfer anomaly", "kind", a.Kind, "owner", a.Owner,
+			"balance", a.Balance, "amount", a.Amount, "policy", a.Policy, "rejected", rejected)
+		metrics.Anomalies.WithLabelValues(a.Kind, string(a.Policy)).Inc()
+	}
+	return nil
+}
+
+// 按合约地址和地址查询链上最近的转账异常, 为空时不过滤
+func FindAnomalies(chain, kid, owner string, limit int) ([]models.Anomaly, error) {
+	var anomalies []models.Anomaly
+	query := MDB.db.Where("chain = ?", chain).Order("id desc").Limit(limit)
+	if kid != "" {
+		query = query.Where("kid = ?", kid)
+	}
+	if owner != "" {
+		query = query.Where("owner = ?", owner)
+	}
+	err := query.Find(&anomalies).Error
+	return anomalies, err
+}
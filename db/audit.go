@@ -0,0 +1,205 @@
+package db
+
+import (
+	"fmt"
+	"gorm.io/gorm/clause"
+	"holders/ledger"
+	"holders/models"
+	"strings"
+)
+
+// 审计发现的问题
+type Issue struct {
+	Kind   string `json:"kind"`
+	Kid    string `json:"kid"`
+	Owner  string `json:"owner"`
+	Detail string `json:"detail"`
+}
+
+const (
+	// 余额小于等于0的记录, 允许负余额时为等于0的记录
+	IssueNonPositive = "non-positive-balance"
+	// 有余额但缺少持有记录
+	IssueMissingHold = "missing-hold"
+	// 有持有记录但没有余额
+	IssueOrphanHold = "orphan-hold"
+	// 有余额表但缺少代币信息
+	IssueMissingToken = "missing-token"
+)
+
+// 按前缀列出数据表
+func tablesWithPrefix(prefix string) ([]string, error) {
+	tables, err := MDB.db.Migrator().GetTables()
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, table := range tables {
+		if strings.HasPrefix(table, prefix) {
+			result = append(result, table)
+		}
+	}
+	return result, nil
+}
+
+// 检查链上的余额表和持有表是否一致, repair为true时修复可自动修复的问题
+// (缺少代币信息需要请求节点, 只报告不修复)
+func Audit(chain string, repair bool) ([]Issue, error) {
+	ns := namespace(chain)
+	var issues []Issue
+
+	tokens := make(map[string]bool)
+	var tokenList []models.Token
+	err := MDB.db.Table(tokensTable(chain)).Select("kid").Find(&tokenList).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokenList {
+		tokens[token.Kid] = true
+	}
+
+	//余额 -> 持有, 按持有表名记录, 过长的地址在表名中为哈希
+	holders := make(map[string]map[string]bool)
+	for _, prefix := range []string{Balance20Prefix, Balance721Prefix} {
+		tables, err := tablesWithPrefix(ns + prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range tables {
+			kid := strings.TrimPrefix(table, ns+prefix)
+			if !tokens[kid] {
+				issues = append(issues, Issue{Kind: IssueMissingToken, Kid: kid})
+			}
+
+			var owners []string
+			if prefix == Balance20Prefix {
+				//允许负余额时只检查为0的记录
+				cond := "amount <= ?"
+				if overdraft == ledger.PolicyNegative || unknownSender == ledger.PolicyNegative {
+					cond = "amount = ?"
+				}
+				var bad []models.Balance20
+				err = MDB.db.Table(table).Where(cond, 0).Find(&bad).Error
+				if err != nil {
+					return nil, err
+				}
+				for _, b := range bad {
+					issues = append(issues, Issue{Kind: IssueNonPositive, Kid: kid, Owner: b.Owner, Detail: fmt.Sprint(b.Amount)})
+				}
+				if repair && len(bad) > 0 {
+					err = MDB.db.Table(table).Where(cond, 0).Delete(nil).Error
+					if err != nil {
+						return nil, err
+					}
+				}
+				err = MDB.db.Table(table).Where("amount > ?", 0).Pluck("owner", &owners).Error
+			} else {
+				err = MDB.db.Table(table).Distinct("owner").Pluck("owner", &owners).Error
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			bip := 20
+			if prefix == Balance721Prefix {
+				bip = 721
+			}
+			for _, owner := range owners {
+				hold := holdTable(chain, owner)
+				if holders[hold] == nil {
+					holders[hold] = make(map[string]bool)
+				}
+				holders[hold][kid] = true
+
+				var count int64
+				if MDB.db.Migrator().HasTable(holdTable(chain, owner)) {
+					err = MDB.db.Table(holdTable(chain, owner)).Where("kid", kid).Count(&count).Error
+					if err != nil {
+						return nil, err
+					}
+				}
+				if count > 0 {
+					continue
+				}
+				issues = append(issues, Issue{Kind: IssueMissingHold, Kid: kid, Owner: owner})
+				if repair {
+					_, err = CreateTable(chain, &models.Wallet{Owner: owner})
+					if err != nil {
+						return nil, err
+					}
+					err = MDB.db.Table(holdTable(chain, owner)).Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Wallet{
+						Kid: kid,
+						Bip: bip,
+					}).Error
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+
+	//持有 -> 余额
+	tables, err := tablesWithPrefix(ns + HoldTablePrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		//过长的地址在表名中为哈希, 此时报告的owner为哈希
+		owner := strings.TrimPrefix(table, ns+HoldTablePrefix)
+		var wallets []models.Wallet
+		err = MDB.db.Table(table).Find(&wallets).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, wallet := range wallets {
+			if holders[table][wallet.Kid] {
+				continue
+			}
+			issues = append(issues, Issue{Kind: IssueOrphanHold, Kid: wallet.Kid, Owner: owner, Detail: fmt.Sprint(wallet.Bip)})
+			if repair {
+				err = MDB.db.Table(table).Where("kid", wallet.Kid).Delete(nil).Error
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// 删除链上全部余额, 持有数据和流水, 并将扫描游标和已写入高度重置为number, 用于从number之后重新索引
+func ResetState(chain string, number int64) error {
+	for _, prefix := range []string{Balance20Prefix, Balance721Prefix, HoldTablePrefix} {
+		tables, err := tablesWithPrefix(namespace(chain) + prefix)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			err = MDB.db.Migrator().DropTable(table)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := MDB.db.Where("chain = ?", chain).Delete(&models.Journal{}).Error
+	if err != nil {
+		return err
+	}
+	//余额已清空, 之后的流水是完整的
+	err = MDB.db.Clauses(clause.OnConflict{UpdateAll: true}).Create([]models.Cursor{
+		{Chain: chain, Number: number},
+		{Chain: journalCursor(chain), Number: number},
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	err = ClearQueue(chain)
+	if err != nil {
+		return err
+	}
+	return WriteNumber(chain, fmt.Sprint(number))
+}
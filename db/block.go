@@ -0,0 +1,159 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"holders/ledger"
+	"holders/metrics"
+	"holders/models"
+	"holders/tracing"
+	"log/slog"
+	"time"
+)
+
+// 在同一事务中写入一个区块的全部转账并更新区块游标, 校验不通过和被余额规则拒绝的转账被跳过,
+// 其余任意一笔失败则整个区块回滚并返回错误. cursor为区块游标键, 重建任务的数据写入其所属的链
+func ApplyBlock(ctx context.Context, cursor string, height int64, transfers []interface{}) (err error) {
+	defer metrics.Since(metrics.DBDuration.WithLabelValues("block"), time.Now())
+	ctx, span := tracing.Start(ctx, "db.apply_block",
+		attribute.String("chain", cursor), attribute.Int64("height", height), attribute.Int("transfers", len(transfers)))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	chain := cursorChain(cursor)
+	var valid []interface{}
+	for _, t := range transfers {
+		var err error
+		switch transfer := t.(type) {
+		case models.Transfer20:
+			err = check20(chain, transfer)
+		case models.Transfer721:
+			err = check721(chain, transfer)
+		}
+		if err != nil {
+			transferLog(chain, height, t).Warn("invalid transfer skipped", "err", err)
+			metrics.Transfers.WithLabelValues(transferKip(t), metrics.Skipped).Inc()
+			continue
+		}
+		valid = append(valid, t)
+	}
+
+	tx := MDB.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	applied := make(map[string]int)
+	for _, t := range valid {
+		err := applyTransfer(tx, chain, t)
+		if err != nil {
+			//余额规则拒绝的转账重试也无法成功, 跳过该转账, 异常记录随区块一起提交
+			if IsDataError(err) {
+				transferLog(chain, height, t).Warn("rejected transfer skipped", "err", err)
+				metrics.Transfers.WithLabelValues(transferKip(t), metrics.Skipped).Inc()
+				continue
+			}
+			//存储错误回滚整个区块, 由调用方按FailPolicy重试或隔离
+			tx.Rollback()
+			transferLog(chain, height, t).Error("apply transfer failed, block rolled back", "err", err)
+			metrics.Transfers.WithLabelValues(transferKip(t), metrics.Failed).Inc()
+			return err
+		}
+		applied[transferKip(t)]++
+	}
+
+	err = tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Cursor{
+		Chain:  cursor,
+		Number: height,
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	//第一次写入区块时记录流水起点, 回退不能早于该高度
+	if cursor == chain {
+		err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Cursor{
+			Chain:  journalCursor(chain),
+			Number: height - 1,
+		}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	err = tx.Commit().Error
+	if err != nil {
+		return err
+	}
+	for kip, n := range applied {
+		metrics.Transfers.WithLabelValues(kip, metrics.Applied).Add(float64(n))
+	}
+	return nil
+}
+
+// 在事务中执行一笔转账并写入流水和异常, 按reject策略拒绝的转账也会记录异常并返回错误
+func applyTransfer(tx *gorm.DB, chain string, t interface{}) error {
+	var (
+		cs  *ledger.Changeset
+		err error
+	)
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		cs, err = transaction20(tx, chain, transfer)
+	case models.Transfer721:
+		cs, err = transaction721(tx, chain, transfer)
+	}
+	var ae *ledger.AnomalyError
+	if errors.As(err, &ae) {
+		rerr := recordAnomalies(tx, chain, t, []ledger.Anomaly{ae.Anomaly}, true)
+		if rerr != nil {
+			return rerr
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	err = writeJournal(tx, chain, t, cs)
+	if err != nil {
+		return err
+	}
+	return recordAnomalies(tx, chain, t, cs.Anomalies, false)
+}
+
+// 转账日志, 带上链, 高度, 交易哈希, 合约地址和事件哈希
+func transferLog(chain string, height int64, t interface{}) *slog.Logger {
+	var kid, txHash, eHash string
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		kid, txHash, eHash = transfer.Kid, transfer.TxHash, transfer.EHash
+	case models.Transfer721:
+		kid, txHash, eHash = transfer.Kid, transfer.TxHash, transfer.EHash
+	}
+	return slog.With("chain", chain, "height", height, "tx_hash", txHash, "kid", kid, "e_hash", eHash)
+}
+
+// 转账记录对应的合约标准
+func transferKip(t interface{}) string {
+	switch t.(type) {
+	case models.Transfer20:
+		return "B20"
+	case models.Transfer721:
+		return "B721"
+	}
+	return ""
+}
+
+// 获取已写入数据库的区块高度
+func AppliedNumber(chain string) (int64, error) {
+	var cursor models.Cursor
+	err := MDB.db.Where("chain = ?", chain).Limit(1).Find(&cursor).Error
+	if err != nil {
+		return 0, err
+	}
+	return cursor.Number, nil
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"holders/conf"
+	"holders/ledger"
+)
+
+// MySQL表名的最大长度
+const maxTableName = 64
+
+// 链的数据表前缀, 默认链没有前缀
+func namespace(chain string) string {
+	return conf.ProfileOf(chain).Namespace
+}
+
+// 链的B20余额表
+func balance20Table(chain, kid string) string {
+	return namespace(chain) + Balance20Prefix + kid
+}
+
+// 链的B721所有权表
+func balance721Table(chain, kid string) string {
+	return namespace(chain) + Balance721Prefix + kid
+}
+
+// 链的钱包持有表, 加上前缀后超过MySQL表名长度时用地址的哈希代替地址
+func holdTable(chain, owner string) string {
+	table := namespace(chain) + HoldTablePrefix + owner
+	if len(table) <= maxTableName {
+		return table
+	}
+	sum := sha256.Sum256([]byte(owner))
+	return namespace(chain) + HoldTablePrefix + hex.EncodeToString(sum[:16])
+}
+
+// 链的代币信息表
+func tokensTable(chain string) string {
+	return namespace(chain) + "tokens"
+}
+
+// 链的LevelDB缓存键
+func cacheKey(chain, key string) string {
+	return namespace(chain) + key
+}
+
+// 链上合约的余额规则
+func rulesOf(chain, kid string) ledger.Rules {
+	rules := ledger.Rules{Overdraft: overdraft, UnknownSender: unknownSender, ZeroAddress: conf.ProfileOf(chain).ZeroAddress}
+	//只有部分历史的合约不拒绝没有余额记录的发送地址, 否则补齐数据时大部分转账都会被跳过
+	if isPartialKid(chain, kid) && (unknownSender == "" || unknownSender == ledger.PolicyReject) {
+		rules.UnknownSender = ledger.PolicyClamp
+	}
+	return rules
+}
@@ -0,0 +1,38 @@
+package db
+
+import "errors"
+
+// 关闭LevelDB和MySQL, 应在扫描和接口服务都停止后调用
+func Close() error {
+	var errs []error
+	if LDB != nil {
+		errs = append(errs, LDB.Close())
+	}
+	if MDB != nil {
+		errs = append(errs, MDB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// 检查已打开的数据库是否可用, 未打开的LevelDB不检查
+func Ping() error {
+	if MDB == nil {
+		return errors.New("mysql is not open")
+	}
+	sqlDB, err := MDB.db.DB()
+	if err != nil {
+		return err
+	}
+	err = sqlDB.Ping()
+	if err != nil {
+		return err
+	}
+
+	if LDB != nil {
+		_, err = LDB.DB.GetProperty("leveldb.num-files-at-level0")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
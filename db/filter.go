@@ -0,0 +1,24 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// 上次启动时使用的索引范围, 键为 tf_<chain>
+const FilterPrefix = "tf_"
+
+// 获取上次保存的索引范围, 未保存时返回nil
+func GetFilter(chain string) ([]byte, error) {
+	data, err := LDB.Get(FilterPrefix + chain)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// 保存当前的索引范围
+func PutFilter(chain string, data []byte) error {
+	return LDB.Put([]byte(FilterPrefix+chain), data)
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"holders/ledger"
+	"holders/models"
+)
+
+// 流水类型
+const (
+	JournalBalance = "b20"
+	JournalToken   = "b721"
+	JournalHold    = "hold"
+)
+
+// 流水操作
+const (
+	JournalInsert = "insert"
+	JournalUpdate = "update"
+	JournalDelete = "delete"
+)
+
+// 撤销的高度范围之后还有流水
+var ErrUndoNotTail = errors.New("只能撤销最近写入的高度")
+
+// 流水起点在cursors表中的键, 值为开始记录流水前已写入的高度, 之后的每个高度都有完整的流水
+func journalCursor(chain string) string {
+	return chain + "/journal"
+}
+
+// 开始记录流水前已写入的高度, 还没有写入过区块时ok为false
+func JournalBase(chain string) (base int64, ok bool, err error) {
+	var cursor models.Cursor
+	result := MDB.db.Where("chain = ?", journalCursor(chain)).Limit(1).Find(&cursor)
+	return cursor.Number, result.RowsAffected > 0, result.Error
+}
+
+func journalOp(op ledger.Op) string {
+	switch op {
+	case ledger.OpInsert:
+		return JournalInsert
+	case ledger.OpUpdate:
+		return JournalUpdate
+	case ledger.OpDelete:
+		return JournalDelete
+	}
+	return ""
+}
+
+// 在事务中按变更顺序写入流水
+func writeJournal(tx *gorm.DB, chain string, t interface{}, cs *ledger.Changeset) error {
+	var height int64
+	var txHash, eHash string
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		height, txHash, eHash = transfer.Height, transfer.TxHash, transfer.EHash
+	case models.Transfer721:
+		height, txHash, eHash = transfer.Height, transfer.TxHash, transfer.EHash
+	}
+
+	var rows []models.Journal
+	for _, b := range cs.Balances {
+		rows = append(rows, models.Journal{
+			Kind:    JournalBalance,
+			Op:      journalOp(b.Op),
+			Kid:     b.Kid,
+			Owner:   b.Owner,
+			Delta:   b.Delta,
+			Balance: b.Amount,
+		})
+	}
+	for _, c := range cs.Tokens {
+		rows = append(rows, models.Journal{
+			Kind:      JournalToken,
+			Op:        journalOp(c.Op),
+			Kid:       c.Kid,
+			Owner:     c.To,
+			TokenId:   c.TokenId,
+			PrevOwner: c.From,
+		})
+	}
+	for _, h := range cs.Holds {
+		rows = append(rows, models.Journal{
+			Kind:  JournalHold,
+			Op:    journalOp(h.Op),
+			Kid:   h.Kid,
+			Owner: h.Owner,
+			Bip:   h.Bip,
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	for i := range rows {
+		rows[i].Chain, rows[i].Height, rows[i].TxHash, rows[i].EHash = chain, height, txHash, eHash
+	}
+	return tx.Create(&rows).Error
+}
+
+// 地址的余额变更, 按时间倒序. kid不为空时只查询该合约, before大于0时只查询id小于before的记录
+func FindJournal(chain, owner, kid string, before int64, limit int) ([]models.JournalEntry, error) {
+	var rows []models.Journal
+	query := MDB.db.Where("chain = ? AND kind <> ?", chain, JournalHold).
+		Where(MDB.db.Where("owner = ?", owner).Or("prev_owner = ?", owner))
+	if kid != "" {
+		query = query.Where("kid = ?", kid)
+	}
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]models.JournalEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := models.JournalEntry{
+			Id:      row.Id,
+			Height:  row.Height,
+			TxHash:  row.TxHash,
+			EHash:   row.EHash,
+			Kid:     row.Kid,
+			Bip:     20,
+			Delta:   row.Delta,
+			Balance: row.Balance,
+		}
+		if row.Kind == JournalToken {
+			entry.Bip, entry.TokenId, entry.Delta = 721, row.TokenId, 1
+			if row.PrevOwner == owner {
+				entry.Delta = -1
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// 按流水撤销 (from, to] 范围内的全部变更, 不需要请求节点. 只能撤销最近写入的高度,
+// 不修改区块游标
+func UndoHeights(chain string, from, to int64) error {
+	return MDB.db.Transaction(func(tx *gorm.DB) error {
+		return undoHeights(tx, chain, from, to)
+	})
+}
+
+func undoHeights(tx *gorm.DB, chain string, from, to int64) error {
+	if from >= to {
+		return fmt.Errorf("invalid height range (%d, %d]", from, to)
+	}
+	var later int64
+	err := tx.Model(&models.Journal{}).Where("chain = ? AND height > ?", chain, to).Count(&later).Error
+	if err != nil {
+		return err
+	}
+	if later > 0 {
+		return ErrUndoNotTail
+	}
+
+	var rows []models.Journal
+	err = tx.Where("chain = ? AND height > ? AND height <= ?", chain, from, to).
+		Order("height desc, id desc").Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		err = undoJournal(tx, row)
+		if err != nil {
+			return fmt.Errorf("undo journal %d: %w", row.Id, err)
+		}
+	}
+	return tx.Where("chain = ? AND height > ? AND height <= ?", chain, from, to).Delete(&models.Journal{}).Error
+}
+
+// 执行一条流水的逆操作
+func undoJournal(tx *gorm.DB, row models.Journal) error {
+	switch row.Kind {
+	case JournalBalance:
+		table := tx.Table(balance20Table(row.Chain, row.Kid))
+		//变更前的余额
+		prev := row.Balance - row.Delta
+		switch row.Op {
+		case JournalInsert:
+			return table.Where("owner", row.Owner).Delete(nil).Error
+		case JournalUpdate:
+			return table.Where("owner", row.Owner).Update("amount", prev).Error
+		case JournalDelete:
+			return table.Create(&models.Balance20{Owner: row.Owner, Amount: prev}).Error
+		}
+	case JournalToken:
+		table := tx.Table(balance721Table(row.Chain, row.Kid))
+		switch row.Op {
+		case JournalInsert:
+			return table.Where("token_id", row.TokenId).Delete(nil).Error
+		case JournalUpdate:
+			return table.Where("token_id", row.TokenId).Update("owner", row.PrevOwner).Error
+		}
+	case JournalHold:
+		table := tx.Table(holdTable(row.Chain, row.Owner))
+		switch row.Op {
+		case JournalInsert:
+			return table.Where("kid", row.Kid).Delete(nil).Error
+		case JournalDelete:
+			return table.Create(&models.Wallet{Kid: row.Kid, Bip: row.Bip}).Error
+		}
+	}
+	return fmt.Errorf("unknown journal %s %s", row.Kind, row.Op)
+}
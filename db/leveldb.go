@@ -1,9 +1,11 @@
 package db
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"strconv"
 	"sync"
+	"syscall"
 
 	"github.com/syndtr/goleveldb/leveldb"
 )
@@ -15,13 +17,21 @@ type LevelClient struct {
 
 var LDB *LevelClient
 
-func init() {
-	db, err := leveldb.OpenFile("data", nil)
+// LevelDB目录已被其他进程打开, 通常是正在运行的扫描进程
+var ErrLevelDBLocked = errors.New("leveldb is locked by another process, stop the scanner first")
+
+// 打开LevelDB, 同一目录同时只能被一个进程打开
+func OpenLevelDB(path string) error {
+	db, err := leveldb.OpenFile(path, nil)
+	if errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.EAGAIN) {
+		return fmt.Errorf("%w: %s", ErrLevelDBLocked, path)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	LDB = &LevelClient{DB: db}
+	return nil
 }
 
 // 打开或创建LevelDB数据库
@@ -29,6 +39,13 @@ func GetLevelDB() *LevelClient {
 	return LDB
 }
 
+// 关闭数据库
+func (c *LevelClient) Close() error {
+	c.mutex.Lock()         // 在操作前加锁
+	defer c.mutex.Unlock() // 确保在操作后解锁
+	return c.DB.Close()
+}
+
 // 批量添加
 func (c *LevelClient) Batch(batch *leveldb.Batch) error {
 	c.mutex.Lock()         // 在操作前加锁
@@ -93,12 +110,12 @@ func WriteNumber(chainId string, number string) error {
 	return err
 }
 
-func PutTokenExits(kid string) error {
-	return LDB.Put([]byte(kid), []byte("bool"))
+func PutTokenExits(chain, kid string) error {
+	return LDB.Put([]byte(cacheKey(chain, kid)), []byte("bool"))
 }
 
-func GetTokenExits(kid string) bool {
-	_, err := LDB.Get(kid)
+func GetTokenExits(chain, kid string) bool {
+	_, err := LDB.Get(cacheKey(chain, kid))
 	if err != nil {
 		if err.Error() == "leveldb: not found" {
 			return false
@@ -107,12 +124,12 @@ func GetTokenExits(kid string) bool {
 	return true
 }
 
-func PutTokenUriExits(kid, tokenId string) error {
-	return LDB.Put([]byte(kid+tokenId), []byte("bool"))
+func PutTokenUriExits(chain, kid, tokenId string) error {
+	return LDB.Put([]byte(cacheKey(chain, kid+tokenId)), []byte("bool"))
 }
 
-func GetTokenUriExits(kid, tokenId string) bool {
-	_, err := LDB.Get(kid + tokenId)
+func GetTokenUriExits(chain, kid, tokenId string) bool {
+	_, err := LDB.Get(cacheKey(chain, kid+tokenId))
 	if err != nil {
 		if err.Error() == "leveldb: not found" {
 			return false
@@ -3,13 +3,18 @@ package db
 import (
 	"errors"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"holders/conf"
+	"holders/ledger"
+	"holders/metrics"
 	"holders/models"
-	"log"
+	"holders/tracing"
+	"log/slog"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // 持有表前缀
@@ -19,6 +24,16 @@ const HoldTablePrefix = "h_"
 const Balance20Prefix = "b2_"
 const Balance721Prefix = "b7_"
 
+var (
+	ErrSameAddress   = ledger.ErrSameAddress
+	ErrInvalidAmount = ledger.ErrInvalidAmount
+)
+
+// 数据本身不合法或被余额规则拒绝导致的错误, 重试也无法成功
+func IsDataError(err error) bool {
+	return errors.Is(err, ErrSameAddress) || errors.Is(err, ErrInvalidAmount) ||
+		errors.Is(err, ledger.ErrNoBalance) || errors.Is(err, ledger.ErrOverdraft)
+}
 
 type MysqlClient struct {
 	db    *gorm.DB
@@ -27,9 +42,8 @@ type MysqlClient struct {
 
 var MDB *MysqlClient
 
-func init() {
-	// 参考 https://github.com/go-sql-driver/mysql#dsn-data-source-name 获取详情
-	dsn := "root:lisp000724@tcp(127.0.0.1:3306)/bits_scanner?charset=utf8mb4&parseTime=True&loc=Local"
+// 连接MySQL, dsn格式参考 https://github.com/go-sql-driver/mysql#dsn-data-source-name
+func OpenMySQL(dsn string) error {
 	db, err := gorm.Open(mysql.New(mysql.Config{
 		DSN:                       dsn,   // DSN data source name
 		DefaultStringSize:         256,   // string 类型字段的默认长度
@@ -40,21 +54,45 @@ func init() {
 	}), &gorm.Config{})
 
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	db.AutoMigrate(&models.Token{})
+	err = db.AutoMigrate(&models.Token{}, &models.Cursor{}, &models.Anomaly{}, &models.Journal{})
+	if err != nil {
+		return err
+	}
+	//其他链的代币信息表
+	for _, p := range conf.Profiles {
+		if p.Namespace == "" {
+			continue
+		}
+		err = db.Table(tokensTable(p.Chain)).AutoMigrate(&models.Token{})
+		if err != nil {
+			return err
+		}
+	}
 
 	MDB = &MysqlClient{
 		db: db,
 	}
+	return nil
 }
 
 func GetMySQL() *MysqlClient {
 	return MDB
 }
 
-func CreateTable(model interface{}) (*string, error) {
+// 关闭数据库连接
+func (c *MysqlClient) Close() error {
+	sqlDB, err := c.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// 按模型创建链上的余额表或持有表
+func CreateTable(chain string, model interface{}) (*string, error) {
 	var tableName string
 	rType := reflect.TypeOf(model)
 	switch rType {
@@ -63,208 +101,152 @@ func CreateTable(model interface{}) (*string, error) {
 		if balance20.Kid == "" {
 			return nil, errors.New("invalid table name")
 		}
-		tableName = Balance20Prefix + balance20.Kid
+		tableName = balance20Table(chain, balance20.Kid)
 	case reflect.TypeOf(&models.Balance721{}):
 		balance721 := model.(*models.Balance721)
 		if balance721.Kid == "" {
 			return nil, errors.New("invalid table name")
 		}
-		tableName = Balance721Prefix + balance721.Kid
+		tableName = balance721Table(chain, balance721.Kid)
 	case reflect.TypeOf(&models.Wallet{}):
 		wallet := model.(*models.Wallet)
 		if wallet.Owner == "" {
 			return nil, errors.New("invalid table name")
 		}
-		tableName = HoldTablePrefix + wallet.Owner
+		tableName = holdTable(chain, wallet.Owner)
 	}
 
 	return &tableName, MDB.db.Table(tableName).AutoMigrate(model)
 }
 
-func InsertValues(model interface{}) error {
-	tableName, err := CreateTable(model)
+func InsertValues(chain string, model interface{}) error {
+	tableName, err := CreateTable(chain, model)
 	if err != nil {
 		return err
 	}
 	return MDB.db.Table(*tableName).Create(model).Error
 }
 
-func Token(token models.Token) error {
-	return MDB.db.Create(token).Error
+// 保存链上的代币信息
+func Token(chain string, token models.Token) error {
+	return MDB.db.Table(tokensTable(chain)).Create(&token).Error
 }
 
 // 代币转移事务
-func Transaction20(transfer20 models.Transfer20) error {
-	if transfer20.From == transfer20.To {
-		return errors.New("接收地址和发送地址一样")
+func Transaction20(chain string, transfer20 models.Transfer20) error {
+	err := check20(chain, transfer20)
+	if err != nil {
+		return err
+	}
+
+	tx := MDB.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
 	}
+	return commitTransfer(tx, chain, transfer20)
+}
+
+// 执行转账并提交事务, 被拒绝的转账只提交异常记录
+func commitTransfer(tx *gorm.DB, chain string, t interface{}) error {
+	err := applyTransfer(tx, chain, t)
+	var ae *ledger.AnomalyError
+	if err != nil && !errors.As(err, &ae) {
+		tx.Rollback()
+		return err
+	}
+	cerr := tx.Commit().Error
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
 
-	if transfer20.Amount < 0 {
-		return errors.New("转移数量小于等于0")
+// 校验代币转移并创建相关表, 建表语句会隐式提交事务, 需在事务开始前执行
+func check20(chain string, transfer20 models.Transfer20) error {
+	err := ledger.Check20(transfer20)
+	if err != nil {
+		return err
 	}
 
-	CreateTable(&models.Balance20{
+	CreateTable(chain, &models.Balance20{
 		Kid: transfer20.Kid,
 	})
 
-	CreateTable(&models.Wallet{
+	CreateTable(chain, &models.Wallet{
 		Owner: transfer20.To,
 	})
+	return nil
+}
 
-	tx := MDB.db.Begin()
-	if tx.Error != nil {
-		return tx.Error
+// 在事务中执行代币转移, 返回写入的变更, 出错时由调用方回滚
+func transaction20(tx *gorm.DB, chain string, transfer20 models.Transfer20) (cs *ledger.Changeset, err error) {
+	defer metrics.Since(metrics.DBDuration.WithLabelValues("transfer20"), time.Now())
+	ctx, span := tracing.Start(tx.Statement.Context, "db.transaction20",
+		attribute.String("kid", transfer20.Kid), attribute.Int64("height", transfer20.Height))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	tx = tx.WithContext(ctx)
+
+	cs, err = rulesOf(chain, transfer20.Kid).Apply20(txState{tx, chain}, transfer20)
+	if err != nil {
+		return nil, err
 	}
+	return cs, persist(tx, chain, cs)
+}
 
-	//发送地址
-	var fBalance models.Balance20
-	if transfer20.From != conf.ZeroAddress {
-		result := tx.Table(Balance20Prefix+transfer20.Kid).Where("owner = ?", transfer20.From).First(&fBalance)
-		//如果owner之前没有数据
-		if result.Error == gorm.ErrRecordNotFound {
-			return result.Error
-		}
-		newBalance := fBalance.Amount - transfer20.Amount
-		if newBalance > 0 {
-			err := tx.Table(Balance20Prefix+transfer20.Kid).Where("owner", transfer20.From).Update("amount", newBalance).Error
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		} else {
-			//删除余额数据
-			err := tx.Table(Balance20Prefix+transfer20.Kid).Where("owner", transfer20.From).Delete(nil).Error
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-			//删除持有数据
-			err = tx.Table(HoldTablePrefix+transfer20.From).Where("kid", transfer20.Kid).Delete(nil).Error
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
+// NFT转移事务
+func Transaction721(chain string, transfer721 models.Transfer721) error {
+	err := check721(chain, transfer721)
+	if err != nil {
+		return err
 	}
 
-	var tBalance models.Balance20
-	//接收地址
-	result := tx.Table(Balance20Prefix+transfer20.Kid).Where("owner = ?", transfer20.To).First(&tBalance)
-	//如果owner之前没有数据
-	if result.Error == gorm.ErrRecordNotFound {
-		//插入余额
-		err := tx.Table(Balance20Prefix + transfer20.Kid).Create(&models.Balance20{
-			Amount: transfer20.Amount,
-			Owner:  transfer20.To,
-		}).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		//插入持有
-		err = tx.Table(HoldTablePrefix + transfer20.To).Create(&models.Wallet{
-			Kid: transfer20.Kid,
-			Bip: 20,
-		}).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		//更新持有
-		newBalance := tBalance.Amount + transfer20.Amount
-		err := tx.Table(Balance20Prefix+transfer20.Kid).Where("owner", transfer20.To).Update("amount", newBalance).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+	tx := MDB.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
 	}
-	return tx.Commit().Error
+	return commitTransfer(tx, chain, transfer721)
 }
 
-// NFT转移事务
-func Transaction721(transfer721 models.Transfer721) error {
-	if transfer721.From == transfer721.To {
-		return errors.New("接收地址和发送地址一样")
+// 校验NFT转移并创建相关表
+func check721(chain string, transfer721 models.Transfer721) error {
+	err := ledger.Check721(transfer721)
+	if err != nil {
+		return err
 	}
 
-	CreateTable(&models.Balance721{
+	CreateTable(chain, &models.Balance721{
 		Kid: transfer721.Kid,
 	})
 
-	CreateTable(&models.Wallet{
+	CreateTable(chain, &models.Wallet{
 		Owner: transfer721.To,
 	})
+	return nil
+}
 
-	tx := MDB.db.Begin()
-
-	//先查询有没有
-	var count int64
-	err := tx.Table(Balance721Prefix+transfer721.Kid).Where("token_id = ?", transfer721.TokenId).Count(&count).Error
-	if count == 0 {
-		//保存tokenId所有者
-		err := tx.Table(Balance721Prefix + transfer721.Kid).Create(&models.Balance721{
-			Owner:   transfer721.To,
-			TokenId: transfer721.TokenId,
-			Data:    transfer721.Data,
-		}).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	} else {
-		//更新tokenId所有者
-		err := tx.Table(Balance721Prefix+transfer721.Kid).Where("token_id", transfer721.TokenId).Update("owner", transfer721.To).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-	}
-
-	var tHold int64
-	if transfer721.From != conf.ZeroAddress {
-		err = tx.Table(Balance721Prefix+transfer721.Kid).Where("owner", transfer721.From).Count(&tHold).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		if tHold == 0 {
-			//删除持有数据
-			err = tx.Table(HoldTablePrefix+transfer721.From).Where("kid", transfer721.Kid).Delete(nil).Error
-			if err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
-	}
-
-	//接收地址
-	//查询更新有持有数量
-	err = tx.Table(Balance721Prefix+transfer721.Kid).Where("owner", transfer721.To).Count(&tHold).Error
+// 在事务中执行NFT转移, 出错时由调用方回滚
+func transaction721(tx *gorm.DB, chain string, transfer721 models.Transfer721) (cs *ledger.Changeset, err error) {
+	defer metrics.Since(metrics.DBDuration.WithLabelValues("transfer721"), time.Now())
+	ctx, span := tracing.Start(tx.Statement.Context, "db.transaction721",
+		attribute.String("kid", transfer721.Kid), attribute.Int64("height", transfer721.Height))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	tx = tx.WithContext(ctx)
+
+	cs, err = rulesOf(chain, transfer721.Kid).Apply721(txState{tx, chain}, transfer721)
 	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	//如果更新后为1,则第一次持有
-	if tHold == 1 {
-		//需要记录持有
-		err = tx.Table(HoldTablePrefix + transfer721.To).Create(&models.Wallet{
-			Kid: transfer721.Kid,
-			Bip: 721,
-		}).Error
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
+		return nil, err
 	}
-
-	return tx.Commit().Error
+	return cs, persist(tx, chain, cs)
 }
 
 // 钱包持有数据
-func FindWalletHold(owner string) (map[string]interface{}, error) {
+func FindWalletHold(chain, owner string) (map[string]interface{}, error) {
 	var tokens []models.Wallet
-	err := MDB.db.Table(HoldTablePrefix + owner).Find(&tokens).Error
+	err := MDB.db.Table(holdTable(chain, owner)).Find(&tokens).Error
 	if err != nil {
 		return nil, err
 	}
@@ -278,14 +260,14 @@ func FindWalletHold(owner string) (map[string]interface{}, error) {
 		switch token.Bip {
 		case 20:
 			var h20 models.Hold
-			err := MDB.db.Table(Balance20Prefix+token.Kid).Where("owner", owner).Find(&h20).Error
+			err := MDB.db.Table(balance20Table(chain, token.Kid)).Where("owner", owner).Find(&h20).Error
 			if err != nil {
-				log.Println(err)
+				slog.Warn("find hold failed", "owner", owner, "kid", token.Kid, "err", err)
 				continue
 			}
-			err = MDB.db.Table("tokens").Where("kid", token.Kid).Find(&h20).Error
+			err = MDB.db.Table(tokensTable(chain)).Where("kid", token.Kid).Find(&h20).Error
 			if err != nil {
-				log.Println(err)
+				slog.Warn("find hold failed", "owner", owner, "kid", token.Kid, "err", err)
 				continue
 			}
 
@@ -294,17 +276,17 @@ func FindWalletHold(owner string) (map[string]interface{}, error) {
 			var h721 models.Hold
 
 			var count int64
-			err := MDB.db.Table(Balance721Prefix+token.Kid).Where("owner", owner).Count(&count).Error
+			err := MDB.db.Table(balance721Table(chain, token.Kid)).Where("owner", owner).Count(&count).Error
 			if err != nil {
-				log.Println(err)
+				slog.Warn("find hold failed", "owner", owner, "kid", token.Kid, "err", err)
 				continue
 			}
 
 			h721.Amount = fmt.Sprint(count)
 
-			err = MDB.db.Table("tokens").Where("kid", token.Kid).Find(&h721).Error
+			err = MDB.db.Table(tokensTable(chain)).Where("kid", token.Kid).Find(&h721).Error
 			if err != nil {
-				log.Println(err)
+				slog.Warn("find hold failed", "owner", owner, "kid", token.Kid, "err", err)
 				continue
 			}
 			hold721s = append(hold721s, h721)
@@ -318,8 +300,8 @@ func FindWalletHold(owner string) (map[string]interface{}, error) {
 }
 
 // 持有的tokenId列表
-func FindTokenIds(kid, owner string) (tokenIds []models.TokenIds, err error) {
-	err = MDB.db.Table(Balance721Prefix+kid).Select("token_id,data").Where("owner", owner).Find(&tokenIds).Error
+func FindTokenIds(chain, kid, owner string) (tokenIds []models.TokenIds, err error) {
+	err = MDB.db.Table(balance721Table(chain, kid)).Select("token_id,data").Where("owner", owner).Find(&tokenIds).Error
 	if err != nil {
 		return nil, err
 	}
@@ -327,7 +309,7 @@ func FindTokenIds(kid, owner string) (tokenIds []models.TokenIds, err error) {
 }
 
 // 获取持有分布
-func FindDist(kid string, is20 bool) ([]models.Dist, error) {
+func FindDist(chain, kid string, is20 bool) ([]models.Dist, error) {
 	var (
 		tableName string
 		query     string
@@ -339,10 +321,10 @@ func FindDist(kid string, is20 bool) ([]models.Dist, error) {
 
 	//如果是代币
 	if is20 {
-		tableName = Balance20Prefix + kid
+		tableName = balance20Table(chain, kid)
 		err = MDB.db.Table(tableName).Order("amount desc").Limit(100).Find(&distList).Error
 	} else {
-		tableName = Balance721Prefix + kid
+		tableName = balance721Table(chain, kid)
 		query = "count(`owner`) as amount,owner"
 		err = MDB.db.Table(tableName).Select(query).Group("owner").Order("amount desc").Limit(100).Find(&distList).Error
 	}
@@ -353,9 +335,9 @@ func FindDist(kid string, is20 bool) ([]models.Dist, error) {
 }
 
 // 查询代币
-func FindToken(kid string) (models.Token, error) {
+func FindToken(chain, kid string) (models.Token, error) {
 	var token models.Token
-	err := MDB.db.Table("tokens").Where("kid", kid).Find(&token).Error
+	err := MDB.db.Table(tokensTable(chain)).Where("kid", kid).Find(&token).Error
 	if err != nil {
 		return models.Token{}, err
 	}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 事件队列前缀, 键为 q_<chain>_<height>, 高度补零保证按高度顺序遍历
+const QueuePrefix = "q_"
+
+func queuePrefix(chain string) []byte {
+	return []byte(QueuePrefix + chain + "_")
+}
+
+func queueKey(chain string, height int64) []byte {
+	return []byte(fmt.Sprintf("%s%s_%020d", QueuePrefix, chain, height))
+}
+
+// 事件入队, 与扫描游标在同一批次中写入, 保证游标前进时事件已落盘
+func EnqueueEvents(chain string, height int64, data []byte) error {
+	batch := new(leveldb.Batch)
+	if data != nil {
+		batch.Put(queueKey(chain, height), data)
+	}
+	batch.Put([]byte(chain), []byte(strconv.FormatInt(height, 10)))
+	return LDB.Batch(batch)
+}
+
+// 隔离区前缀, 键为 qq_<chain>_<height>, 保存多次处理失败的区块
+const QuarantinePrefix = "qq_"
+
+type QueueEntry struct {
+	Height int64
+	Data   []byte
+}
+
+// 按高度顺序读取after之后的至多limit个队列项
+func ScanQueue(chain string, after int64, limit int) ([]QueueEntry, error) {
+	prefix := queuePrefix(chain)
+	rang := util.BytesPrefix(prefix)
+	rang.Start = queueKey(chain, after+1)
+	iter := LDB.DB.NewIterator(rang, nil)
+	defer iter.Release()
+
+	var entries []QueueEntry
+	for len(entries) < limit && iter.Next() {
+		height, err := strconv.ParseInt(string(iter.Key()[len(prefix):]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, len(iter.Value()))
+		copy(data, iter.Value())
+		entries = append(entries, QueueEntry{Height: height, Data: data})
+	}
+	return entries, iter.Error()
+}
+
+// 确认整个高度已处理完成
+func AckQueue(chain string, height int64) error {
+	return LDB.Delete(string(queueKey(chain, height)))
+}
+
+type Quarantined struct {
+	Height int64  `json:"height"`
+	Reason string `json:"reason"`
+	Data   []byte `json:"data"`
+}
+
+// 将处理失败的区块事件移入隔离区, 待人工排查
+func Quarantine(chain string, height int64, data []byte, reason string) error {
+	value, err := json.Marshal(Quarantined{Height: height, Reason: reason, Data: data})
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s_%020d", QuarantinePrefix, chain, height)
+	return LDB.Put([]byte(key), value)
+}
+
+// 队列中等待处理的区块数量
+func QueueLen(chain string) int {
+	iter := LDB.DB.NewIterator(util.BytesPrefix(queuePrefix(chain)), nil)
+	defer iter.Release()
+
+	n := 0
+	for iter.Next() {
+		n++
+	}
+	return n
+}
+
+// 清空某条链的事件队列
+func ClearQueue(chain string) error {
+	batch := new(leveldb.Batch)
+	iter := LDB.DB.NewIterator(util.BytesPrefix(queuePrefix(chain)), nil)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return LDB.Batch(batch)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"gorm.io/gorm"
+	"holders/models"
+)
+
+// 持有的NFT及其所有者
+type HeldToken struct {
+	TokenId string `json:"tokenId"`
+	Owner   string `json:"owner"`
+}
+
+// 对账用的索引数据, 已写入高度和抽样记录来自同一个快照
+type ReconcileSample struct {
+	Height int64
+	// 合约类型, 未索引时为0
+	Bip      int
+	Balances []models.Balance20
+	Tokens   []HeldToken
+	// 抽样NFT的所有者持有的NFT数量
+	Counts map[string]int64
+}
+
+// 在同一个只读事务中读取已写入高度和抽样数据, 避免扫描继续写入导致高度与数据不对应.
+// n<=0时返回全部
+func SampleReconcile(chain, kid string, n int) (*ReconcileSample, error) {
+	s := &ReconcileSample{Bip: TokenBip(chain, kid)}
+	err := MDB.db.Transaction(func(tx *gorm.DB) error {
+		var cursor models.Cursor
+		err := tx.Where("chain = ?", chain).Limit(1).Find(&cursor).Error
+		if err != nil {
+			return err
+		}
+		s.Height = cursor.Number
+
+		switch s.Bip {
+		case 20:
+			query := tx.Table(balance20Table(chain, kid))
+			if n > 0 {
+				query = query.Order("RAND()").Limit(n)
+			}
+			return query.Find(&s.Balances).Error
+		case 721:
+			return sampleTokens721(tx, chain, kid, n, s)
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func sampleTokens721(tx *gorm.DB, chain, kid string, n int, s *ReconcileSample) error {
+	query := tx.Table(balance721Table(chain, kid)).Select("token_id,owner")
+	if n > 0 {
+		query = query.Order("RAND()").Limit(n)
+	}
+	err := query.Find(&s.Tokens).Error
+	if err != nil {
+		return err
+	}
+
+	s.Counts = make(map[string]int64)
+	var owners []string
+	for _, t := range s.Tokens {
+		if _, ok := s.Counts[t.Owner]; !ok {
+			s.Counts[t.Owner] = 0
+			owners = append(owners, t.Owner)
+		}
+	}
+	if len(owners) == 0 {
+		return nil
+	}
+	var counts []struct {
+		Owner string
+		Count int64
+	}
+	err = tx.Table(balance721Table(chain, kid)).Select("owner, COUNT(*) AS count").
+		Where("owner IN ?", owners).Group("owner").Find(&counts).Error
+	if err != nil {
+		return err
+	}
+	for _, c := range counts {
+		s.Counts[c.Owner] = c.Count
+	}
+	return nil
+}
+
+// 根据已有的余额表判断合约类型, 未索引时返回0
+func TokenBip(chain, kid string) int {
+	switch {
+	case MDB.db.Migrator().HasTable(balance20Table(chain, kid)):
+		return 20
+	case MDB.db.Migrator().HasTable(balance721Table(chain, kid)):
+		return 721
+	}
+	return 0
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"holders/models"
+)
+
+// 合约首次出现的高度, 键为 fs_<chain>_<kid>
+const FirstSeenPrefix = "fs_"
+
+// 未完成的单合约重建任务, 键为 ri_<chain>_<kid>, 值为起始高度
+const ReindexPrefix = "ri_"
+
+// 记录合约首次出现的高度, 已记录时不覆盖
+func PutFirstSeen(chain, kid string, height int64) error {
+	key := FirstSeenPrefix + chain + "_" + kid
+	_, err := LDB.Get(key)
+	if err == nil {
+		return nil
+	}
+	return LDB.Put([]byte(key), []byte(strconv.FormatInt(height, 10)))
+}
+
+// 获取合约首次出现的高度, 未记录时返回0
+func FirstSeen(chain, kid string) int64 {
+	return int64(FistNumber(FirstSeenPrefix + chain + "_" + kid))
+}
+
+// 重建任务在cursors表中的进度键
+func ReindexCursor(chain, kid string) string {
+	return chain + "/reindex/" + kid
+}
+
+// 游标键对应的链, 重建任务的游标键返回其所属的链
+func cursorChain(cursor string) string {
+	chain, _, _ := strings.Cut(cursor, "/reindex/")
+	return chain
+}
+
+// 保存重建任务, 重启后继续执行
+func SaveReindex(chain, kid string, from int64) error {
+	return LDB.Put([]byte(ReindexPrefix+chain+"_"+kid), []byte(strconv.FormatInt(from, 10)))
+}
+
+// 删除已完成的重建任务及其进度
+func DeleteReindex(chain, kid string) error {
+	err := MDB.db.Where("chain = ?", ReindexCursor(chain, kid)).Delete(&models.Cursor{}).Error
+	if err != nil {
+		return err
+	}
+	return LDB.Delete(ReindexPrefix + chain + "_" + kid)
+}
+
+// 未完成的重建任务, 合约地址 -> 起始高度
+func ReindexJobs(chain string) (map[string]int64, error) {
+	prefix := []byte(ReindexPrefix + chain + "_")
+	iter := LDB.DB.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	jobs := make(map[string]int64)
+	for iter.Next() {
+		from, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+		if err != nil {
+			continue
+		}
+		jobs[string(iter.Key()[len(prefix):])] = from
+	}
+	return jobs, iter.Error()
+}
+
+// 删除链上某个合约的余额表, 所有持有记录和流水
+func DropToken(chain, kid string) error {
+	for _, table := range []string{balance20Table(chain, kid), balance721Table(chain, kid)} {
+		if !MDB.db.Migrator().HasTable(table) {
+			continue
+		}
+		var owners []string
+		err := MDB.db.Table(table).Distinct("owner").Pluck("owner", &owners).Error
+		if err != nil {
+			return err
+		}
+		for _, owner := range owners {
+			if !MDB.db.Migrator().HasTable(holdTable(chain, owner)) {
+				continue
+			}
+			err = MDB.db.Table(holdTable(chain, owner)).Where("kid", kid).Delete(nil).Error
+			if err != nil {
+				return err
+			}
+		}
+		err = MDB.db.Migrator().DropTable(table)
+		if err != nil {
+			return err
+		}
+	}
+	return MDB.db.Where("chain = ? AND kid = ?", chain, kid).Delete(&models.Journal{}).Error
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"holders/models"
+	"sort"
+	"strconv"
+)
+
+// 回退结果
+type RewindResult struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// 撤销的流水条数
+	Journal int64 `json:"journal"`
+	// 首次出现在to之后, 被删除的合约
+	Tokens []string `json:"tokens"`
+}
+
+// 将chain的索引状态回退到高度to: 按流水撤销to之后的余额, 持有和所有权变更, 删除之后才出现的代币,
+// 并将已写入高度和扫描游标重置为to, to不能早于开始记录流水的高度. MySQL中的变更在同一事务中提交,
+// 之后再清空队列并更新LevelDB中的游标; 中途失败时可以重新执行. 调用前需停止该链的扫描
+func Rewind(chain string, to int64) (*RewindResult, error) {
+	applied, err := AppliedNumber(chain)
+	if err != nil {
+		return nil, err
+	}
+	if to < 0 || to > applied {
+		return nil, fmt.Errorf("invalid rewind height %d, applied height is %d", to, applied)
+	}
+	//开始记录流水之前的高度无法撤销
+	if to < applied {
+		base, ok, err := JournalBase(chain)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || to < base {
+			return nil, fmt.Errorf("cannot rewind to %d, journal starts after height %d", to, base)
+		}
+	}
+	result := &RewindResult{From: applied, To: to}
+
+	seen, err := firstSeenAfter(chain, to)
+	if err != nil {
+		return nil, err
+	}
+	for kid := range seen {
+		result.Tokens = append(result.Tokens, kid)
+	}
+	sort.Strings(result.Tokens)
+
+	err = MDB.db.Transaction(func(tx *gorm.DB) error {
+		if applied > to {
+			err := tx.Model(&models.Journal{}).Where("chain = ? AND height > ?", chain, to).Count(&result.Journal).Error
+			if err != nil {
+				return err
+			}
+			err = undoHeights(tx, chain, to, applied)
+			if err != nil {
+				return err
+			}
+		}
+		err := tx.Where("chain = ? AND height > ?", chain, to).Delete(&models.Anomaly{}).Error
+		if err != nil {
+			return err
+		}
+		if len(result.Tokens) > 0 {
+			err = tx.Table(tokensTable(chain)).Where("kid IN ?", result.Tokens).Delete(&models.Token{}).Error
+			if err != nil {
+				return err
+			}
+		}
+		//重建任务的进度不能超过回退后的高度
+		err = tx.Model(&models.Cursor{}).Where("chain LIKE ? AND number > ?", ReindexCursor(chain, "%"), to).
+			Update("number", to).Error
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.Cursor{
+			Chain:  chain,
+			Number: to,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//余额表已由流水撤销清空, 建表语句会隐式提交事务, 在事务之外删除
+	for _, kid := range result.Tokens {
+		err = DropToken(chain, kid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ClearQueue(chain)
+	if err != nil {
+		return nil, err
+	}
+	batch := new(leveldb.Batch)
+	for kid := range seen {
+		batch.Delete([]byte(FirstSeenPrefix + chain + "_" + kid))
+		batch.Delete([]byte(ReindexPrefix + chain + "_" + kid))
+		batch.Delete([]byte(cacheKey(chain, kid)))
+	}
+	batch.Put([]byte(chain), []byte(strconv.FormatInt(to, 10)))
+	err = LDB.Batch(batch)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 首次出现高度在height之后的合约
+func firstSeenAfter(chain string, height int64) (map[string]int64, error) {
+	prefix := []byte(FirstSeenPrefix + chain + "_")
+	iter := LDB.DB.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+
+	kids := make(map[string]int64)
+	for iter.Next() {
+		seen, err := strconv.ParseInt(string(iter.Value()), 10, 64)
+		if err != nil || seen <= height {
+			continue
+		}
+		kids[string(iter.Key()[len(prefix):])] = seen
+	}
+	return kids, iter.Error()
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"holders/ledger"
+	"holders/models"
+)
+
+// 在事务中读取状态, 可以看到事务内已写入的变更
+type txState struct {
+	tx    *gorm.DB
+	chain string
+}
+
+func (s txState) Balance20(kid, owner string) (float64, bool, error) {
+	var balance models.Balance20
+	err := s.tx.Table(balance20Table(s.chain, kid)).Where("owner = ?", owner).First(&balance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return balance.Amount, true, nil
+}
+
+func (s txState) Owner721(kid, tokenId string) (string, bool, error) {
+	var balances []models.Balance721
+	err := s.tx.Table(balance721Table(s.chain, kid)).Where("token_id = ?", tokenId).Limit(1).Find(&balances).Error
+	if err != nil || len(balances) == 0 {
+		return "", false, err
+	}
+	return balances[0].Owner, true, nil
+}
+
+func (s txState) Count721(kid, owner string) (int64, error) {
+	var count int64
+	err := s.tx.Table(balance721Table(s.chain, kid)).Where("owner", owner).Count(&count).Error
+	return count, err
+}
+
+// 在事务中写入变更
+func persist(tx *gorm.DB, chain string, cs *ledger.Changeset) error {
+	for _, b := range cs.Balances {
+		table := tx.Table(balance20Table(chain, b.Kid))
+		var err error
+		switch b.Op {
+		case ledger.OpInsert:
+			err = table.Create(&models.Balance20{
+				Amount: b.Amount,
+				Owner:  b.Owner,
+			}).Error
+		case ledger.OpUpdate:
+			err = table.Where("owner", b.Owner).Update("amount", b.Amount).Error
+		case ledger.OpDelete:
+			err = table.Where("owner", b.Owner).Delete(nil).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, t := range cs.Tokens {
+		table := tx.Table(balance721Table(chain, t.Kid))
+		var err error
+		switch t.Op {
+		case ledger.OpInsert:
+			err = table.Create(&models.Balance721{
+				Owner:   t.To,
+				TokenId: t.TokenId,
+				Data:    t.Data,
+			}).Error
+		case ledger.OpUpdate:
+			err = table.Where("token_id", t.TokenId).Update("owner", t.To).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, h := range cs.Holds {
+		table := tx.Table(holdTable(chain, h.Owner))
+		var err error
+		switch h.Op {
+		case ledger.OpInsert:
+			err = table.Create(&models.Wallet{
+				Kid: h.Kid,
+				Bip: h.Bip,
+			}).Error
+		case ledger.OpDelete:
+			err = table.Where("kid", h.Kid).Delete(nil).Error
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
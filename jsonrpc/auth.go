@@ -0,0 +1,233 @@
+package jsonrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 日志中替换敏感信息
+const redacted = "[REDACTED]"
+
+// 节点访问配置, 零值表示不鉴权, 不使用代理, 使用系统证书
+type Options struct {
+	// Authorization: Bearer <BearerToken>
+	BearerToken string
+	// Basic auth
+	Username string
+	Password string
+	// 附加的请求头, 如 X-Api-Key
+	Headers map[string]string
+	// HTTP代理地址, 为空时使用环境变量 HTTP_PROXY/HTTPS_PROXY
+	Proxy string
+	// 额外信任的CA证书文件(PEM)
+	CAFile string
+	// 双向TLS的客户端证书和私钥(PEM)
+	CertFile string
+	KeyFile  string
+
+	// 将所有请求和响应追加写入该文件
+	Record string
+	// 从录制文件返回响应, 不访问节点
+	Replay string
+}
+
+// 所有请求需要附加的请求头
+func (o Options) Header() http.Header {
+	h := make(http.Header)
+	for k, v := range o.Headers {
+		h.Set(k, v)
+	}
+	switch {
+	case o.BearerToken != "":
+		h.Set("Authorization", "Bearer "+o.BearerToken)
+	case o.Username != "" || o.Password != "":
+		h.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(o.Username+":"+o.Password)))
+	}
+	return h
+}
+
+// 代理设置, 用于http.Transport和websocket.Dialer
+func (o Options) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if o.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(o.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s: %w", RedactURL(o.Proxy), errors.Unwrap(err))
+	}
+	return http.ProxyURL(u), nil
+}
+
+// 按CA和客户端证书生成TLS配置, 未配置时返回nil
+func (o Options) TLSConfig() (*tls.Config, error) {
+	if o.CAFile == "" && o.CertFile == "" && o.KeyFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// 按配置创建http客户端
+func (o Options) httpClient() (*http.Client, error) {
+	if o.Replay != "" {
+		if o.Record != "" {
+			return nil, errors.New("record and replay cannot be used together")
+		}
+		rp, err := newReplayer(o.Replay)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rp}, nil
+	}
+
+	proxy, err := o.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	t := newTransport()
+	t.Proxy = proxy
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	if o.Record != "" {
+		rec, err := newRecorder(t, o.Record)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rec}, nil
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// 日志输出时隐藏密钥
+func (o Options) LogValue() slog.Value {
+	headers := make([]string, 0, len(o.Headers))
+	for k := range o.Headers {
+		headers = append(headers, k+": "+redacted)
+	}
+	attrs := []slog.Attr{
+		slog.String("proxy", RedactURL(o.Proxy)),
+		slog.Any("headers", headers),
+		slog.String("ca_file", o.CAFile),
+		slog.String("cert_file", o.CertFile),
+		slog.String("record", o.Record),
+		slog.String("replay", o.Replay),
+	}
+	if o.BearerToken != "" {
+		attrs = append(attrs, slog.String("bearer_token", redacted))
+	}
+	if o.Username != "" {
+		attrs = append(attrs, slog.String("username", o.Username), slog.String("password", redacted))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func (o Options) String() string {
+	return o.LogValue().String()
+}
+
+// 隐藏地址中的密码和查询参数, 查询参数中常带有API key
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q.Set(k, "xxxxx")
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// 解析 "Name: value" 格式的请求头
+func ParseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", errors.New(`invalid header, expected "Name: value"`)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+var (
+	optionsMutex   sync.RWMutex
+	defaultOptions Options
+	defaultHeader  = http.Header{}
+)
+
+// 设置NewClient使用的默认访问配置
+func SetDefaultOptions(o Options) error {
+	client, err := o.httpClient()
+	if err != nil {
+		return err
+	}
+	optionsMutex.Lock()
+	defer optionsMutex.Unlock()
+	defaultOptions = o
+	defaultHeader = o.Header()
+	defaultHTTPClient = client
+	return nil
+}
+
+// 当前的默认访问配置
+func DefaultOptions() Options {
+	optionsMutex.RLock()
+	defer optionsMutex.RUnlock()
+	return defaultOptions
+}
+
+// 使用指定访问配置创建客户端
+func NewClientWithOptions(nodeUrl string, o Options) (*Client, error) {
+	c, err := newClient(nodeUrl)
+	if err != nil {
+		return nil, err
+	}
+	c.http, err = o.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	c.header = o.Header()
+	return c, nil
+}
@@ -1,15 +1,17 @@
 package jsonrpc
 
 import (
-	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/bwmarrin/snowflake"
-	"io"
+	"go.opentelemetry.io/otel/attribute"
+	"holders/metrics"
+	"holders/tracing"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // JSONRPCRequest 定义JSON-RPC请求的结构体
@@ -20,18 +22,6 @@ type JSONRPCRequest struct {
 	ID      string          `json:"id"`
 }
 
-// JSONRPCResponse 定义JSON-RPC响应的结构体
-type JSONRPCResponse struct {
-	JSONRPC string        `json:"jsonrpc"`
-	Result  JSONResult    `json:"result"`
-	Error   *JSONRPCError `json:"error"`
-	ID      string        `json:"id"`
-}
-
-type JSONResult struct {
-	Data interface{} `json:"data"`
-}
-
 // JSONRPCError 定义JSON-RPC错误的结构体
 type JSONRPCError struct {
 	Code    int    `json:"code"`
@@ -39,79 +29,73 @@ type JSONRPCError struct {
 }
 
 type Client struct {
-	url string `json:"url"`
+	url  string `json:"url"`
+	ctx  context.Context
+	http *http.Client
+	// 为nil时使用默认访问配置
+	header http.Header
 }
 
 var rpcClient *Client
 
+// 节点未找到对应数据
+var ErrNotFind = errors.New("not find")
+
+// 使用默认访问配置创建客户端, 参考SetDefaultOptions
 func NewClient(nodeUrl string) (*Client, error) {
+	c, err := newClient(nodeUrl)
+	if err != nil {
+		return nil, err
+	}
+	rpcClient = c
+
+	return rpcClient, nil
+}
+
+func newClient(nodeUrl string) (*Client, error) {
 	if nodeUrl == "" {
 		return nil, errors.New("err: nodeUrl invalid")
 	}
 	if !strings.HasPrefix(nodeUrl, "http://") && !strings.HasPrefix(nodeUrl, "https://") {
 		return nil, errors.New("err: only http or https requests are supported")
 	}
-
-	rpcClient = &Client{url: nodeUrl}
-
-	return rpcClient, nil
+	return &Client{url: nodeUrl}, nil
 }
 
 func GetClient() *Client {
 	return rpcClient
 }
 
-// 发送JSON-RPC请求的函数
-func sendJSONRPCRequest(url string, request JSONRPCRequest) (*JSONRPCResponse, error) {
-	// 将请求结构体编码为JSON
-	requestBytes, err := json.Marshal(request)
-	if err != nil {
-		return nil, err
-	}
-
-	// 创建一个HTTP客户端
-	client := &http.Client{}
-	// 创建一个HTTP请求
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	// 设置Content-Type为application/json
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发送请求并获取响应
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// 返回绑定ctx的客户端副本, 请求的span挂在ctx之下, ctx取消时请求中止
+func (c *Client) WithContext(ctx context.Context) *Client {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
 
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
 	}
+	return c.ctx
+}
 
-	// 读取响应体
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+func (c *Client) httpClient() *http.Client {
+	if c.http == nil {
+		optionsMutex.RLock()
+		defer optionsMutex.RUnlock()
+		return defaultHTTPClient
 	}
+	return c.http
+}
 
-	// 将响应体解码为JSON-RPC响应结构体
-	var response JSONRPCResponse
-
-	err = json.Unmarshal(responseBody, &response)
-	if err != nil {
-		return nil, err
-	}
-	// 如果响应包含错误，返回错误
-	if response.Error != nil {
-		data, _ := json.Marshal(response.Error)
-		return nil, errors.New(string(data))
+func (c *Client) headers() http.Header {
+	if c.header == nil {
+		optionsMutex.RLock()
+		defer optionsMutex.RUnlock()
+		return defaultHeader
 	}
-
-	return &response, nil
+	return c.header
 }
 
 func (c *Client) CallContract(param CallParam) (any, error) {
@@ -179,165 +163,126 @@ func (c *Client) GetTransaction(param TransactionParam) (any, error) {
 	return c.Call("getTransaction", pByte)
 }
 
+// 调用节点方法并记录耗时, 错误和span, 请求前等待限流许可
 func (c *Client) Call(method string, param []byte) (any, error) {
-	node, err := snowflake.NewNode(1)
+	ctx, span := tracing.Start(c.context(), "jsonrpc."+method, attribute.String("rpc.method", method))
+	release, err := acquire(ctx, method)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, err
 	}
-	// Generate a snowflake ID.
-	id := node.Generate()
-
-	// 创建一个JSON-RPC请求
-	request := JSONRPCRequest{
-		JSONRPC: "2.0",
-		Method:  method, // 假设的方法名，需要匹配服务器端的方法
-		Params:  param,  // 参数，这里是一个JSON数组
-		ID:      id.String(),
-	}
-	// 发送请求并获取响应
-	response, err := sendJSONRPCRequest(c.url, request)
+	defer release()
+
+	start := time.Now()
+	result, err := c.call(ctx, method, param)
+	metrics.Since(metrics.RPCDuration.WithLabelValues(method), start)
 	if err != nil {
-		return nil, err
+		metrics.RPCErrors.WithLabelValues(method).Inc()
 	}
-	result := response.Result
+	tracing.End(span, err)
+	return result, err
+}
 
+func (c *Client) call(ctx context.Context, method string, param []byte) (any, error) {
 	switch method {
 	case "ord_call":
-		return result.pResult()
+		return send[any](ctx, c, method, param)
 	case "bestBlockNumber":
-		return result.pBestBlockNumber()
-		case "getScriptModel":
-		return result.pScriptModel()
+		return send[int64](ctx, c, method, param)
+	case "getScriptModel":
+		return c.pScriptModel(ctx, param)
 	case "getTokenModel":
-		return result.pTokenModel()
+		return c.pTokenModel(ctx, param)
 	case "getTokenUri":
-		return result.pTokenUri()
+		return c.pTokenUri(ctx, param)
 	case "getEvents":
-		return result.pEvents()
+		return send[[]Event](ctx, c, method, param)
 	case "getBlockNumber":
-		return result.pBlockNumber()
+		return c.pBlockNumber(ctx, param)
 	case "getTransaction":
-		return result.pTransaction()
+		return c.pTransaction(ctx, param)
 	}
 
-	return nil, nil
+	_, err := send[json.RawMessage](ctx, c, method, param)
+	return nil, err
 }
 
-func (r JSONResult) pScriptModel() (*Script, error) {
-	var sm Script
-	if r.Data == nil {
-		return nil, errors.New("not find")
-	}
-	m := r.Data.(map[string]interface{})
-	sm.Abi = m["abi"]
-	sm.Kip = m["bip"].(string)
-	return &sm, nil
+// 节点返回的脚本模型
+type scriptData struct {
+	Abi interface{} `json:"abi"`
+	Bip string      `json:"bip"`
 }
 
-func (r JSONResult) pTokenModel() (*Token, error) {
-	var t Token
-
-	if r.Data == nil {
-		return nil, errors.New("token not find")
+func (c *Client) pScriptModel(ctx context.Context, param []byte) (*Script, error) {
+	data, err := send[*scriptData](ctx, c, "getScriptModel", param)
+	if err != nil {
+		return nil, err
 	}
-
-	m := r.Data.(map[string]interface{})
-	t.Name = m["Name"].(string)
-	t.Symbol = m["Symbol"].(string)
-	t.TotalSupply = fmt.Sprint(m["TotalSupply"])
-	if m["Owner"] != nil {
-		t.Owner = m["Owner"].(string)
+	if data == nil {
+		return nil, ErrNotFind
 	}
-	return &t, nil
+	return &Script{Abi: data.Abi, Kip: data.Bip}, nil
 }
 
-func (r JSONResult) pTokenUri() (*string, error) {
-	if r.Data == nil {
-		return nil, errors.New("tokenUri is empty")
-	}
-	uri := r.Data.(string)
-	return &uri, nil
+// 节点返回的代币模型, TotalSupply可能是数字或字符串
+type tokenData struct {
+	Name        string      `json:"Name"`
+	Symbol      string      `json:"Symbol"`
+	TotalSupply interface{} `json:"TotalSupply"`
+	Owner       string      `json:"Owner"`
 }
 
-func (r JSONResult) pResult() (interface{}, error) {
-	if r.Data == nil {
-		return nil, nil
+func (c *Client) pTokenModel(ctx context.Context, param []byte) (*Token, error) {
+	data, err := send[*tokenData](ctx, c, "getTokenModel", param)
+	if err != nil {
+		return nil, err
 	}
-	return r.Data, nil
+	if data == nil {
+		return nil, errors.New("token not find")
+	}
+	return &Token{
+		Name:        data.Name,
+		Symbol:      data.Symbol,
+		TotalSupply: fmt.Sprint(data.TotalSupply),
+		Owner:       data.Owner,
+	}, nil
 }
 
-func (r JSONResult) pEvents() ([]Event, error) {
-	var evenList []Event
-	if r.Data == nil {
-		return nil, nil
+func (c *Client) pTokenUri(ctx context.Context, param []byte) (*string, error) {
+	uri, err := send[*string](ctx, c, "getTokenUri", param)
+	if err != nil {
+		return nil, err
 	}
-	eInterfaces := r.Data.([]interface{})
-	for _, eInter := range eInterfaces {
-		m := eInter.(map[string]interface{})
-		var event Event
-		event.KID = m["kid"].(string)
-		event.EHash = m["e_hash"].(string)
-		event.TxHash = m["tx_hash"].(string)
-		event.Height = int64(m["height"].(float64))
-		event.Name = m["name"].(string)
-
-		if m["args"] != nil {
-			event.Args = m["args"].(map[string]interface{})
-		}
-
-		event.TimeStamp = int64(m["timestamp"].(float64))
-		evenList = append(evenList, event)
+	if uri == nil {
+		return nil, errors.New("tokenUri is empty")
 	}
-	return evenList, nil
-}
-
-func (r JSONResult) pBestBlockNumber() (int64, error) {
-	return int64(r.Data.(float64)), nil
+	return uri, nil
 }
 
-func (r JSONResult) pBlockNumber() ([]Transaction, error) {
+func (c *Client) pBlockNumber(ctx context.Context, param []byte) ([]Transaction, error) {
+	data, err := send[[]*Transaction](ctx, c, "getBlockNumber", param)
+	if err != nil {
+		return nil, err
+	}
 	var txList []Transaction
-	tInterfaces := r.Data.([]interface{})
-	for _, tInter := range tInterfaces {
-		if tInter == nil {
+	for _, t := range data {
+		if t == nil {
 			continue
 		}
-		m := tInter.(map[string]interface{})
-		var t Transaction
-		t.Height = int64(m["height"].(float64))
-		t.TxHash = m["tx_hash"].(string)
-		t.Sender = m["sender"].(string)
-		t.KID = m["kid"].(string)
-		t.OP = m["op"].(string)
-		t.Input = m["input"].(string)
-		t.Out = m["out"]
-		t.Logs = m["logs"]
-		t.TimeStamp = int64(m["timestamp"].(float64))
-		t.Status = int(m["status"].(float64))
-		txList = append(txList, t)
+		txList = append(txList, *t)
 	}
 	return txList, nil
 }
 
-func (r JSONResult) pTransaction() (*Transaction, error) {
-	var t Transaction
-
-	if r.Data == nil {
+func (c *Client) pTransaction(ctx context.Context, param []byte) (*Transaction, error) {
+	t, err := send[*Transaction](ctx, c, "getTransaction", param)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
 		return nil, errors.New("transaction not find")
 	}
-
-	m := r.Data.(map[string]interface{})
-	t.Height = int64(m["height"].(float64))
-	t.TxHash = m["tx_hash"].(string)
-	t.Sender = m["sender"].(string)
-	t.KID = m["kid"].(string)
-	t.OP = m["op"].(string)
-	t.Input = m["input"].(string)
-	t.Out = m["out"]
-	t.Logs = m["logs"]
-	t.TimeStamp = int64(m["timestamp"].(float64))
-	t.Status = int(m["status"].(float64))
-	return &t, nil
+	return t, nil
 }
 
 func DecodeBytes(hexStr string) ([]byte, error) {
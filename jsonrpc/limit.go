@@ -0,0 +1,158 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"golang.org/x/time/rate"
+	"holders/metrics"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 请求限制, 0表示不限制
+type Limit struct {
+	// 每秒请求数
+	Rate float64
+	// 令牌桶容量, 为0时取 max(1, Rate)
+	Burst int
+	// 同时进行的最大请求数
+	MaxInFlight int
+}
+
+// 令牌桶限流加并发上限
+type limiter struct {
+	rate *rate.Limiter
+	sem  chan struct{}
+}
+
+func newLimiter(l Limit) *limiter {
+	lim := &limiter{}
+	if l.Rate > 0 {
+		burst := l.Burst
+		if burst <= 0 {
+			burst = int(math.Max(1, math.Ceil(l.Rate)))
+		}
+		lim.rate = rate.NewLimiter(rate.Limit(l.Rate), burst)
+	}
+	if l.MaxInFlight > 0 {
+		lim.sem = make(chan struct{}, l.MaxInFlight)
+	}
+	return lim
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	if l.rate != nil {
+		err := l.rate.Wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *limiter) release() {
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+var (
+	limitsMutex sync.RWMutex
+	// 所有请求共享
+	globalLimiter = newLimiter(Limit{})
+	// 单个方法的额外限制
+	methodLimiters = map[string]*limiter{}
+)
+
+// 设置进程内所有客户端共享的请求限制, methods中的方法在全局限制之外再受各自的限制
+func SetLimits(global Limit, methods map[string]Limit) {
+	ml := make(map[string]*limiter, len(methods))
+	for method, l := range methods {
+		ml[method] = newLimiter(l)
+	}
+
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+	globalLimiter = newLimiter(global)
+	methodLimiters = ml
+}
+
+// 等待获得请求许可, 返回的函数在请求结束后调用
+func acquire(ctx context.Context, method string) (func(), error) {
+	limitsMutex.RLock()
+	global, ml := globalLimiter, methodLimiters[method]
+	limitsMutex.RUnlock()
+
+	start := time.Now()
+	defer metrics.Since(metrics.RPCWait.WithLabelValues(method), start)
+
+	if ml != nil {
+		err := ml.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := global.acquire(ctx)
+	if err != nil {
+		if ml != nil {
+			ml.release()
+		}
+		return nil, err
+	}
+
+	inFlight := metrics.RPCInFlight.WithLabelValues(method)
+	inFlight.Inc()
+	return func() {
+		inFlight.Dec()
+		global.release()
+		if ml != nil {
+			ml.release()
+		}
+	}, nil
+}
+
+// 解析单个方法的限制, 格式为 method=rate[:burst[:inflight]], 多个之间用逗号分隔,
+// 例如 getEvents=20:40:8,getTokenUri=5
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		method, spec, ok := strings.Cut(item, "=")
+		if !ok || method == "" {
+			return nil, fmt.Errorf("invalid limit %q", item)
+		}
+		var (
+			l   Limit
+			err error
+		)
+		parts := strings.Split(spec, ":")
+		if len(parts) > 3 {
+			return nil, fmt.Errorf("invalid limit %q", item)
+		}
+		l.Rate, err = strconv.ParseFloat(parts[0], 64)
+		if err == nil && len(parts) > 1 {
+			l.Burst, err = strconv.Atoi(parts[1])
+		}
+		if err == nil && len(parts) > 2 {
+			l.MaxInFlight, err = strconv.Atoi(parts[2])
+		}
+		if err != nil || l.Rate < 0 || l.Burst < 0 || l.MaxInFlight < 0 {
+			return nil, fmt.Errorf("invalid limit %q", item)
+		}
+		limits[method] = l
+	}
+	return limits, nil
+}
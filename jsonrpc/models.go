@@ -4,6 +4,7 @@ type CallParam struct {
 	KID    string      `json:"kid"`
 	Method string      `json:"method"`
 	Params   interface{} `json:"params"`
+	Number string      `json:"number,omitempty"` //查询的区块高度, 为空时查询最新状态
 }
 
 type EventParam struct {
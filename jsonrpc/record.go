@@ -0,0 +1,181 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// 回放文件中没有对应的请求
+var ErrNotRecorded = errors.New("request not recorded")
+
+// 录制文件中的一条记录, 每行一条
+type Recording struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Status int             `json:"status"`
+	// 响应体, 不是JSON时保存为字符串
+	Response json.RawMessage `json:"response"`
+}
+
+// 读取请求体中的方法和参数, 并恢复请求体
+func readRequest(req *http.Request) (string, json.RawMessage, error) {
+	if req.Body == nil {
+		return "", nil, errors.New("empty request body")
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
+	var r JSONRPCRequest
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		return "", nil, err
+	}
+	return r.Method, compact(r.Params), nil
+}
+
+func compact(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var b bytes.Buffer
+	if json.Compact(&b, raw) != nil {
+		return raw
+	}
+	return b.Bytes()
+}
+
+// 请求的回放键
+func recordKey(method string, params json.RawMessage) string {
+	return method + " " + string(params)
+}
+
+// 录制所有请求和响应的RoundTripper
+type recorder struct {
+	next  http.RoundTripper
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// 打开录制文件, 追加写入
+func newRecorder(next http.RoundTripper, path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{next: next, w: f}, nil
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, params, err := readRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec := Recording{Method: method, Params: params, Status: resp.StatusCode, Response: compact(body)}
+	if !json.Valid(body) {
+		rec.Response, _ = json.Marshal(string(body))
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// 按录制文件返回响应的RoundTripper, 不访问网络.
+// 同一请求有多条记录时按顺序返回, 用完后重复最后一条
+type replayer struct {
+	mutex     sync.Mutex
+	responses map[string][]Recording
+}
+
+// 读取录制文件
+func newReplayer(path string) (*replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRecordings(f)
+}
+
+func readRecordings(r io.Reader) (*replayer, error) {
+	rp := &replayer{responses: make(map[string][]Recording)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec Recording
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		key := recordKey(rec.Method, compact(rec.Params))
+		rp.responses[key] = append(rp.responses[key], rec)
+	}
+	return rp, scanner.Err()
+}
+
+func (rp *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	method, params, err := readRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rp.mutex.Lock()
+	key := recordKey(method, params)
+	recs := rp.responses[key]
+	if len(recs) == 0 {
+		rp.mutex.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrNotRecorded, key)
+	}
+	rec := recs[0]
+	if len(recs) > 1 {
+		rp.responses[key] = recs[1:]
+	}
+	rp.mutex.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+		StatusCode:    rec.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(rec.Response)),
+		ContentLength: int64(len(rec.Response)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,66 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, _, _ := readRequest(r)
+		switch method {
+		case "bestBlockNumber":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","result":{"data":853100}}`)
+		case "getEvents":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","result":{"data":[{"kid":"bit1","e_hash":"e1","tx_hash":"t1","height":853024,"name":"Transfer","args":{"from":"a","to":"b","amount":"10"},"timestamp":1}]}}`)
+		default:
+			http.Error(w, "unknown method", http.StatusBadRequest)
+		}
+	}))
+	defer node.Close()
+
+	file := filepath.Join(t.TempDir(), "node.jsonl")
+	rec, err := NewClientWithOptions(node.URL, Options{Record: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	best, err := rec.BestBlockNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := rec.GetEvents(EventParam{Number: "853024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Close()
+
+	//回放时不访问节点
+	rp, err := NewClientWithOptions(node.URL, Options{Replay: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayedBest, err := rp.BestBlockNumber()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayedBest != best {
+		t.Errorf("best block number = %v, want %v", replayedBest, best)
+	}
+	replayedEvents, err := rp.GetEvents(EventParam{Number: "853024"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(replayedEvents, events) {
+		t.Errorf("events = %+v, want %+v", replayedEvents, events)
+	}
+
+	_, err = rp.GetEvents(EventParam{Number: "853025"})
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("unrecorded request error = %v, want %v", err, ErrNotRecorded)
+	}
+}
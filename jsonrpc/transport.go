@@ -0,0 +1,103 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 所有客户端共享的连接池, 保持长连接避免每次请求重新握手
+var defaultHTTPClient = &http.Client{Transport: newTransport()}
+
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 256
+	t.MaxIdleConnsPerHost = 64
+	t.IdleConnTimeout = 90 * time.Second
+	// 不手动设置Accept-Encoding, 由Transport请求gzip并透明解压
+	t.DisableCompression = false
+	return t
+}
+
+// 请求ID, 进程内递增即可
+var requestID atomic.Uint64
+
+func nextID() string {
+	return strconv.FormatUint(requestID.Add(1), 10)
+}
+
+// 响应结构, result.data直接解码为T
+type response[T any] struct {
+	JSONRPC string `json:"jsonrpc"`
+	Result  struct {
+		Data T `json:"data"`
+	} `json:"result"`
+	Error *JSONRPCError `json:"error"`
+}
+
+// 发送请求并将result.data流式解码为T
+func send[T any](ctx context.Context, c *Client, method string, param []byte) (T, error) {
+	var zero T
+	request := JSONRPCRequest{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  param,
+		ID:      nextID(),
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return zero, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return zero, err
+	}
+	for k, v := range c.headers() {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// 传递trace上下文
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		//错误信息中带有请求地址, 隐藏其中的密钥
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			ue.URL = RedactURL(ue.URL)
+		}
+		return zero, err
+	}
+	defer func() {
+		//读完剩余内容, 连接才能复用
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return zero, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	var r response[T]
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return zero, err
+	}
+	// 如果响应包含错误，返回错误
+	if r.Error != nil {
+		data, _ := json.Marshal(r.Error)
+		return zero, errors.New(string(data))
+	}
+	return r.Result.Data, nil
+}
@@ -0,0 +1,325 @@
+// 余额规则, 与存储无关: 根据当前状态计算一笔转账产生的变更, 由存储层负责写入
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"holders/conf"
+	"holders/models"
+)
+
+var (
+	ErrSameAddress   = errors.New("接收地址和发送地址一样")
+	ErrInvalidAmount = errors.New("转移数量小于等于0")
+	// 发送地址没有余额记录
+	ErrNoBalance = errors.New("发送地址没有余额")
+	// 发送地址余额不足
+	ErrOverdraft = errors.New("发送地址余额不足")
+)
+
+// 发送地址没有余额记录或余额不足时的处理策略
+type Policy string
+
+const (
+	// 拒绝该转账
+	PolicyReject Policy = "reject"
+	// 发送地址余额扣到0为止, 接收地址照常入账
+	PolicyClamp Policy = "clamp"
+	// 允许发送地址余额为负, 负余额不计入持有
+	PolicyNegative Policy = "negative"
+)
+
+// 异常类型
+const (
+	AnomalyUnknownSender = "unknown-sender"
+	AnomalyOverdraft     = "overdraft"
+)
+
+// 乱序或不一致数据导致的异常, 由存储层连同高度和交易一起记录
+type Anomaly struct {
+	Kind    string
+	Kid     string
+	Owner   string
+	Balance float64
+	Amount  float64
+	Policy  Policy
+}
+
+// 按PolicyReject拒绝转账时返回的错误
+type AnomalyError struct {
+	Anomaly Anomaly
+	Err     error
+}
+
+func (e *AnomalyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AnomalyError) Unwrap() error {
+	return e.Err
+}
+
+// 余额规则的配置, 零值拒绝没有余额记录的发送地址, 余额不足时扣到0, 使用conf.ZeroAddress
+type Rules struct {
+	// 发送地址余额不足时的处理策略, 零值为PolicyClamp
+	Overdraft Policy
+	// 发送地址没有余额记录时的处理策略, 零值为PolicyReject
+	UnknownSender Policy
+	// 黑洞地址, 从该地址发出视为铸造
+	ZeroAddress string
+}
+
+func (r Rules) zeroAddress() string {
+	if r.ZeroAddress == "" {
+		return conf.ZeroAddress
+	}
+	return r.ZeroAddress
+}
+
+func (r Rules) overdraft() Policy {
+	if r.Overdraft == "" {
+		return PolicyClamp
+	}
+	return r.Overdraft
+}
+
+func (r Rules) unknownSender() Policy {
+	if r.UnknownSender == "" {
+		return PolicyReject
+	}
+	return r.UnknownSender
+}
+
+// 校验策略名称
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case PolicyReject, PolicyClamp, PolicyNegative:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid overdraft policy %q", s)
+}
+
+// 变更类型
+type Op int
+
+const (
+	OpInsert Op = iota + 1
+	OpUpdate
+	OpDelete
+)
+
+// B20余额变更, Amount为变更后的余额
+type BalanceChange struct {
+	Op     Op
+	Kid    string
+	Owner  string
+	Delta  float64
+	Amount float64
+}
+
+// B721所有权变更
+type TokenChange struct {
+	Op      Op
+	Kid     string
+	TokenId string
+	// 变更前的所有者, 新铸造时为空
+	From string
+	To   string
+	Data string
+}
+
+// 持有记录变更, 只有插入和删除
+type HoldChange struct {
+	Op    Op
+	Owner string
+	Kid   string
+	Bip   int
+}
+
+// 一笔转账产生的全部变更, 按顺序写入
+type Changeset struct {
+	Balances []BalanceChange
+	Tokens   []TokenChange
+	Holds    []HoldChange
+	// 应用转账时发现的异常
+	Anomalies []Anomaly
+}
+
+// 计算变更所需读取的状态
+type State interface {
+	// B20余额, 没有记录时ok为false
+	Balance20(kid, owner string) (amount float64, ok bool, err error)
+	// B721所有者, 没有记录时ok为false
+	Owner721(kid, tokenId string) (owner string, ok bool, err error)
+	// owner持有的B721数量
+	Count721(kid, owner string) (int64, error)
+}
+
+// 按默认规则计算转账的变更, t为models.Transfer20或models.Transfer721
+func Apply(s State, t interface{}) (*Changeset, error) {
+	return Rules{}.Apply(s, t)
+}
+
+// 按默认规则计算B20转账的变更
+func Apply20(s State, t models.Transfer20) (*Changeset, error) {
+	return Rules{}.Apply20(s, t)
+}
+
+// 按默认规则计算B721转移的变更
+func Apply721(s State, t models.Transfer721) (*Changeset, error) {
+	return Rules{}.Apply721(s, t)
+}
+
+// 计算转账的变更, t为models.Transfer20或models.Transfer721
+func (r Rules) Apply(s State, t interface{}) (*Changeset, error) {
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		return r.Apply20(s, transfer)
+	case models.Transfer721:
+		return r.Apply721(s, transfer)
+	}
+	return nil, fmt.Errorf("unknown transfer %T", t)
+}
+
+// 校验B20转账
+func Check20(t models.Transfer20) error {
+	if t.From == t.To {
+		return ErrSameAddress
+	}
+	if t.Amount < 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+// 校验B721转账
+func Check721(t models.Transfer721) error {
+	if t.From == t.To {
+		return ErrSameAddress
+	}
+	return nil
+}
+
+// B20转账: 黑洞地址发出视为铸造; 发送方余额扣到0时删除余额和持有;
+// 接收方第一次持有时插入余额和持有. 发送方没有余额时按UnknownSender处理, 余额不足时按Overdraft处理.
+// 持有记录只对应不为负的余额, 负余额恢复到0时删除余额
+func (r Rules) Apply20(s State, t models.Transfer20) (*Changeset, error) {
+	err := Check20(t)
+	if err != nil {
+		return nil, err
+	}
+	cs := &Changeset{}
+
+	//发送地址
+	if t.From != r.zeroAddress() {
+		balance, ok, err := s.Balance20(t.Kid, t.From)
+		if err != nil {
+			return nil, err
+		}
+		policy := r.overdraft()
+		if !ok {
+			policy = r.unknownSender()
+		}
+		if !ok || balance < t.Amount {
+			a := Anomaly{Kind: AnomalyOverdraft, Kid: t.Kid, Owner: t.From, Balance: balance, Amount: t.Amount, Policy: policy}
+			err := ErrOverdraft
+			if !ok {
+				a.Kind = AnomalyUnknownSender
+				err = ErrNoBalance
+			}
+			if policy == PolicyReject {
+				return nil, &AnomalyError{Anomaly: a, Err: err}
+			}
+			cs.Anomalies = append(cs.Anomalies, a)
+		}
+
+		newBalance := balance - t.Amount
+		switch {
+		case !ok:
+			//没有余额记录, clamp时不扣减
+			if policy == PolicyNegative && newBalance < 0 {
+				cs.Balances = append(cs.Balances, BalanceChange{Op: OpInsert, Kid: t.Kid, Owner: t.From, Delta: -t.Amount, Amount: newBalance})
+			}
+		case newBalance > 0:
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpUpdate, Kid: t.Kid, Owner: t.From, Delta: -t.Amount, Amount: newBalance})
+		case newBalance < 0 && policy == PolicyNegative:
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpUpdate, Kid: t.Kid, Owner: t.From, Delta: -t.Amount, Amount: newBalance})
+			if balance >= 0 {
+				cs.Holds = append(cs.Holds, HoldChange{Op: OpDelete, Owner: t.From, Kid: t.Kid, Bip: 20})
+			}
+		default:
+			//删除余额和持有
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpDelete, Kid: t.Kid, Owner: t.From, Delta: -balance})
+			if balance >= 0 {
+				cs.Holds = append(cs.Holds, HoldChange{Op: OpDelete, Owner: t.From, Kid: t.Kid, Bip: 20})
+			}
+		}
+	}
+
+	//接收地址
+	balance, ok, err := s.Balance20(t.Kid, t.To)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cs.Balances = append(cs.Balances, BalanceChange{Op: OpInsert, Kid: t.Kid, Owner: t.To, Delta: t.Amount, Amount: t.Amount})
+		cs.Holds = append(cs.Holds, HoldChange{Op: OpInsert, Owner: t.To, Kid: t.Kid, Bip: 20})
+	} else {
+		newBalance := balance + t.Amount
+		switch {
+		case balance < 0 && newBalance == 0:
+			//负余额恢复到0, 与发送方扣到0一致, 删除余额且不插入持有
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpDelete, Kid: t.Kid, Owner: t.To, Delta: t.Amount})
+		case balance < 0 && newBalance > 0:
+			//负余额恢复
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpUpdate, Kid: t.Kid, Owner: t.To, Delta: t.Amount, Amount: newBalance})
+			cs.Holds = append(cs.Holds, HoldChange{Op: OpInsert, Owner: t.To, Kid: t.Kid, Bip: 20})
+		default:
+			cs.Balances = append(cs.Balances, BalanceChange{Op: OpUpdate, Kid: t.Kid, Owner: t.To, Delta: t.Amount, Amount: newBalance})
+		}
+	}
+	return cs, nil
+}
+
+// B721转移: 更新或铸造tokenId的所有者; 发送方不再持有该合约时删除持有;
+// 接收方持有数量从0变为1时插入持有
+func (r Rules) Apply721(s State, t models.Transfer721) (*Changeset, error) {
+	err := Check721(t)
+	if err != nil {
+		return nil, err
+	}
+	cs := &Changeset{}
+	tokenId := fmt.Sprint(t.TokenId)
+
+	owner, ok, err := s.Owner721(t.Kid, tokenId)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cs.Tokens = append(cs.Tokens, TokenChange{Op: OpInsert, Kid: t.Kid, TokenId: tokenId, To: t.To, Data: t.Data})
+	} else {
+		cs.Tokens = append(cs.Tokens, TokenChange{Op: OpUpdate, Kid: t.Kid, TokenId: tokenId, From: owner, To: t.To})
+	}
+
+	if t.From != r.zeroAddress() {
+		count, err := s.Count721(t.Kid, t.From)
+		if err != nil {
+			return nil, err
+		}
+		if ok && owner == t.From {
+			count--
+		}
+		if count == 0 {
+			cs.Holds = append(cs.Holds, HoldChange{Op: OpDelete, Owner: t.From, Kid: t.Kid, Bip: 721})
+		}
+	}
+
+	count, err := s.Count721(t.Kid, t.To)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 && !(ok && owner == t.To) {
+		cs.Holds = append(cs.Holds, HoldChange{Op: OpInsert, Owner: t.To, Kid: t.Kid, Bip: 721})
+	}
+	return cs, nil
+}
@@ -0,0 +1,122 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"holders/conf"
+	"holders/models"
+	"math/rand"
+	"testing"
+)
+
+// 随机转账后检查: 余额总和等于铸造总量(clamp时加上少扣的部分), 持有记录与余额/所有权一致
+func TestApplyInvariants(t *testing.T) {
+	policies := []Policy{PolicyClamp, PolicyReject, PolicyNegative}
+	for _, unknown := range policies {
+		for _, overdraft := range policies {
+			t.Run(string(unknown)+"/"+string(overdraft), func(t *testing.T) {
+				testInvariants(t, Rules{UnknownSender: unknown, Overdraft: overdraft})
+			})
+		}
+	}
+}
+
+func testInvariants(t *testing.T, r Rules) {
+	rnd := rand.New(rand.NewSource(1))
+	owners := []string{conf.ZeroAddress, "a", "b", "c", "d"}
+	s := NewMemState()
+	var minted float64
+	//负余额恢复到0的次数
+	var zeroed int
+
+	for i := 0; i < 5000; i++ {
+		from := owners[rnd.Intn(len(owners))]
+		to := owners[rnd.Intn(len(owners))]
+
+		var (
+			cs     *Changeset
+			err    error
+			amount float64
+		)
+		balance, hasBalance := s.Balances["k20"][from]
+		policy := r.overdraft()
+		if !hasBalance {
+			policy = r.unknownSender()
+		}
+		received, hadReceived := s.Balances["k20"][to]
+		if rnd.Intn(2) == 0 {
+			amount = float64(rnd.Intn(50))
+			cs, err = r.Apply(s, models.Transfer20{Kid: "k20", From: from, To: to, Amount: amount})
+		} else {
+			tokenId := fmt.Sprint(rnd.Intn(20))
+			if owner, ok := s.Owners["k721"][tokenId]; ok {
+				from = owner
+			}
+			cs, err = r.Apply(s, models.Transfer721{Kid: "k721", From: from, To: to, TokenId: tokenId})
+		}
+
+		var ae *AnomalyError
+		switch {
+		case from == to:
+			if !errors.Is(err, ErrSameAddress) {
+				t.Fatalf("transfer %d: err = %v, want %v", i, err, ErrSameAddress)
+			}
+			continue
+		case errors.As(err, &ae):
+			if policy != PolicyReject {
+				t.Fatalf("transfer %d: unexpected %v", i, err)
+			}
+			continue
+		case err != nil:
+			t.Fatalf("transfer %d: %v", i, err)
+		}
+
+		if amount > 0 {
+			switch {
+			case from == conf.ZeroAddress:
+				minted += amount
+			case policy == PolicyNegative:
+				//允许负余额时总量守恒
+			case policy == PolicyReject && (!hasBalance || balance < amount):
+				t.Fatalf("transfer %d: overdraft of %v from %v was not rejected", i, amount, balance)
+			default:
+				//clamp时发送方只扣到0, 差额由接收方多得
+				minted += amount - min(balance, amount)
+			}
+		}
+		s.Persist(cs)
+
+		//负余额恢复到0时删除余额, 不保留0余额和持有
+		if amount > 0 && hadReceived && received < 0 && received+amount == 0 {
+			zeroed++
+			if _, ok := s.Balances["k20"][to]; ok {
+				t.Fatalf("transfer %d: zero balance of %s kept", i, to)
+			}
+		}
+
+		var total float64
+		for _, amount := range s.Balances["k20"] {
+			total += amount
+		}
+		if total != minted {
+			t.Fatalf("transfer %d: total balance = %v, want %v", i, total, minted)
+		}
+		for _, owner := range owners {
+			balance, hasBalance := s.Balances["k20"][owner]
+			if _, held := s.Holds[owner]["k20"]; held != (hasBalance && balance >= 0) {
+				t.Fatalf("transfer %d: %s holds k20 = %v, balance = %v", i, owner, held, balance)
+			}
+			//黑洞地址作为发送方时视为铸造, 不会删除其持有记录
+			if owner == conf.ZeroAddress {
+				continue
+			}
+			count, _ := s.Count721("k721", owner)
+			if _, held := s.Holds[owner]["k721"]; held != (count > 0) {
+				t.Fatalf("transfer %d: %s holds k721 = %v, owns %d tokens", i, owner, held, count)
+			}
+		}
+	}
+	if r.Overdraft == PolicyNegative && zeroed == 0 {
+		t.Fatal("no negative balance returned to zero")
+	}
+}
@@ -0,0 +1,71 @@
+package ledger
+
+// 内存中的状态, 用于测试和不需要持久化的场景
+type MemState struct {
+	// kid -> owner -> 余额
+	Balances map[string]map[string]float64
+	// kid -> tokenId -> 所有者
+	Owners map[string]map[string]string
+	// owner -> kid -> bip
+	Holds map[string]map[string]int
+}
+
+func NewMemState() *MemState {
+	return &MemState{
+		Balances: make(map[string]map[string]float64),
+		Owners:   make(map[string]map[string]string),
+		Holds:    make(map[string]map[string]int),
+	}
+}
+
+func (m *MemState) Balance20(kid, owner string) (float64, bool, error) {
+	amount, ok := m.Balances[kid][owner]
+	return amount, ok, nil
+}
+
+func (m *MemState) Owner721(kid, tokenId string) (string, bool, error) {
+	owner, ok := m.Owners[kid][tokenId]
+	return owner, ok, nil
+}
+
+func (m *MemState) Count721(kid, owner string) (int64, error) {
+	var count int64
+	for _, o := range m.Owners[kid] {
+		if o == owner {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// 写入变更
+func (m *MemState) Persist(cs *Changeset) {
+	for _, b := range cs.Balances {
+		switch b.Op {
+		case OpInsert, OpUpdate:
+			if m.Balances[b.Kid] == nil {
+				m.Balances[b.Kid] = make(map[string]float64)
+			}
+			m.Balances[b.Kid][b.Owner] = b.Amount
+		case OpDelete:
+			delete(m.Balances[b.Kid], b.Owner)
+		}
+	}
+	for _, t := range cs.Tokens {
+		if m.Owners[t.Kid] == nil {
+			m.Owners[t.Kid] = make(map[string]string)
+		}
+		m.Owners[t.Kid][t.TokenId] = t.To
+	}
+	for _, h := range cs.Holds {
+		switch h.Op {
+		case OpInsert:
+			if m.Holds[h.Owner] == nil {
+				m.Holds[h.Owner] = make(map[string]int)
+			}
+			m.Holds[h.Owner][h.Kid] = h.Bip
+		case OpDelete:
+			delete(m.Holds[h.Owner], h.Kid)
+		}
+	}
+}
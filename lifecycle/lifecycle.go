@@ -0,0 +1,93 @@
+package lifecycle
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// 管理后台任务和退出顺序: 收到SIGINT/SIGTERM或调用Stop后取消任务ctx,
+// 等待所有任务返回, 再按注册的逆序执行停止钩子
+type Manager struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mutex   sync.Mutex
+	hooks   []hook
+	timeout time.Duration
+}
+
+// timeout为退出的总期限, 超时后不再等待未完成的任务
+func New(timeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{ctx: ctx, cancel: cancel, timeout: timeout}
+}
+
+// 任务ctx, 退出时取消
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// 启动后台任务, fn应在ctx取消后尽快返回
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		fn(m.ctx)
+		slog.Info("task stopped", "task", name)
+	}()
+}
+
+// 注册停止钩子, 在所有任务返回后按注册的逆序执行
+func (m *Manager) OnStop(name string, fn func(ctx context.Context) error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// 主动触发退出
+func (m *Manager) Stop() {
+	m.cancel()
+}
+
+// 阻塞直到收到退出信号或调用Stop, 然后依次停止任务和执行钩子
+func (m *Manager) Wait() {
+	sigCtx, stop := signal.NotifyContext(m.ctx, os.Interrupt, syscall.SIGTERM)
+	<-sigCtx.Done()
+	stop()
+	slog.Info("shutting down")
+	m.cancel()
+
+	deadline, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-deadline.Done():
+		slog.Warn("shutdown timeout, tasks still running")
+	}
+
+	m.mutex.Lock()
+	hooks := m.hooks
+	m.mutex.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].fn(deadline)
+		if err != nil {
+			slog.Error("stop hook failed", "hook", hooks[i].name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// 日志格式
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// 按级别(debug, info, warn, error)和格式配置全局slog, 标准库log也输出到同一处
+func Setup(level, format string) error {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.ToUpper(level)))
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	var handler slog.Handler
+	switch format {
+	case FormatText:
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "holders"
+
+// 转账写入结果
+const (
+	Applied = "applied"
+	Skipped = "skipped"
+	Failed  = "failed"
+)
+
+var (
+	// 已扫描的区块数
+	HeightsScanned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "heights_scanned_total",
+		Help:      "Heights fetched from the node and queued.",
+	}, []string{"chain"})
+
+	// 已写入区块中的事件数, 每个事件只统计一次, 非转账事件的kip为空
+	Events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Events in applied blocks by kip and event name.",
+	}, []string{"kip", "name"})
+
+	// 转账写入结果
+	Transfers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfers_total",
+		Help:      "Transfers written to storage by kip and result.",
+	}, []string{"kip", "result"})
+
+	// 转账异常, kind为unknown-sender或overdraft
+	Anomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transfer_anomalies_total",
+		Help:      "Transfers whose sender had no balance or too little, by kind and overdraft policy.",
+	}, []string{"kind", "policy"})
+
+	// 节点请求耗时
+	RPCDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "jsonrpc call latency by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// 节点请求错误
+	RPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "jsonrpc call errors by method.",
+	}, []string{"method"})
+
+	// 节点请求等待限流的时间
+	RPCWait = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_wait_seconds",
+		Help:      "Time jsonrpc calls spent waiting for the rate limiter and in-flight cap, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	// 正在进行的节点请求
+	RPCInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rpc_in_flight",
+		Help:      "jsonrpc calls currently in flight, by method.",
+	}, []string{"method"})
+
+	// MySQL事务耗时, op为transfer20, transfer721或block
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_duration_seconds",
+		Help:      "MySQL transaction duration by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"op"})
+
+	// 接口请求耗时
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+// 记录从start开始的耗时
+func Since(h prometheus.Observer, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,445 @@
+// 进程内的模拟brc20pm节点, 基于httptest, 按脚本化的链数据响应jsonrpc请求,
+// 支持模拟分叉, 错误和延迟, 用于在单机上端到端测试scanner, db和service
+package mocknode
+
+import (
+	"encoding/json"
+	"fmt"
+	"holders/jsonrpc"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 一个区块的内容
+type Block struct {
+	Events       []jsonrpc.Event       `json:"events"`
+	Transactions []jsonrpc.Transaction `json:"transactions"`
+}
+
+// 节点返回的合约脚本
+type Script struct {
+	Abi interface{} `json:"abi"`
+	Bip string      `json:"bip"`
+}
+
+// 节点返回的代币信息
+type Token struct {
+	Name        string      `json:"Name"`
+	Symbol      string      `json:"Symbol"`
+	TotalSupply interface{} `json:"TotalSupply"`
+	Owner       string      `json:"Owner,omitempty"`
+}
+
+// 合约调用及其返回值
+type Call struct {
+	KID    string      `json:"kid"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+	Result interface{} `json:"result"`
+}
+
+// 链数据脚本, 区块从Start+1开始依次排列
+type Fixture struct {
+	Start   int64             `json:"start"`
+	Blocks  []Block           `json:"blocks"`
+	Scripts map[string]Script `json:"scripts"`
+	Tokens  map[string]Token  `json:"tokens"`
+	// kid -> tokenId -> uri
+	TokenUris map[string]map[string]string `json:"tokenUris"`
+	Calls     []Call                       `json:"calls"`
+}
+
+// 读取JSON格式的链数据脚本
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// 注入的故障
+type fault struct {
+	// 剩余次数
+	count int
+	// HTTP状态码, 为0时返回JSON-RPC错误
+	status int
+}
+
+type Node struct {
+	server *httptest.Server
+
+	mutex    sync.Mutex
+	start    int64
+	blocks   []Block
+	scripts  map[string]Script
+	tokens   map[string]Token
+	uris     map[string]string
+	calls    map[string]interface{}
+	faults   map[string]*fault
+	latency  time.Duration
+	requests map[string]int
+}
+
+// 启动空链的模拟节点, 第一个区块高度为start+1
+func New(start int64) *Node {
+	n := &Node{
+		start:    start,
+		scripts:  make(map[string]Script),
+		tokens:   make(map[string]Token),
+		uris:     make(map[string]string),
+		calls:    make(map[string]interface{}),
+		faults:   make(map[string]*fault),
+		requests: make(map[string]int),
+	}
+	n.server = httptest.NewServer(http.HandlerFunc(n.serve))
+	return n
+}
+
+// 按脚本启动模拟节点
+func NewFromFixture(f *Fixture) *Node {
+	n := New(f.Start)
+	for _, b := range f.Blocks {
+		n.AddBlock(b)
+	}
+	for kid, s := range f.Scripts {
+		n.SetScript(kid, s.Bip, s.Abi)
+	}
+	for kid, t := range f.Tokens {
+		n.SetToken(kid, t)
+	}
+	for kid, uris := range f.TokenUris {
+		for tokenId, uri := range uris {
+			n.SetTokenUri(kid, tokenId, uri)
+		}
+	}
+	for _, c := range f.Calls {
+		n.SetCall(c.KID, c.Method, c.Params, c.Result)
+	}
+	return n
+}
+
+// jsonrpc地址
+func (n *Node) URL() string {
+	return n.server.URL
+}
+
+func (n *Node) Close() {
+	n.server.Close()
+}
+
+// 最新区块高度
+func (n *Node) Best() int64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.best()
+}
+
+func (n *Node) best() int64 {
+	return n.start + int64(len(n.blocks))
+}
+
+// 在链尾追加区块, 事件和交易的高度由节点填写, 返回新区块的高度
+func (n *Node) AddBlock(b Block) int64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	height := n.best() + 1
+	b.Events = append([]jsonrpc.Event(nil), b.Events...)
+	for i := range b.Events {
+		b.Events[i].Height = height
+		if b.Events[i].EHash == "" {
+			b.Events[i].EHash = fmt.Sprintf("e%d-%d", height, i)
+		}
+		if b.Events[i].TxHash == "" {
+			b.Events[i].TxHash = fmt.Sprintf("t%d-%d", height, i)
+		}
+	}
+	b.Transactions = append([]jsonrpc.Transaction(nil), b.Transactions...)
+	for i := range b.Transactions {
+		b.Transactions[i].Height = height
+	}
+	n.blocks = append(n.blocks, b)
+	return height
+}
+
+// 追加只包含事件的区块
+func (n *Node) AddEvents(events ...jsonrpc.Event) int64 {
+	return n.AddBlock(Block{Events: events})
+}
+
+// 模拟分叉: 丢弃最后depth个区块, 再追加新的区块
+func (n *Node) Reorg(depth int, blocks ...Block) {
+	n.mutex.Lock()
+	if depth > len(n.blocks) {
+		depth = len(n.blocks)
+	}
+	n.blocks = n.blocks[:len(n.blocks)-depth]
+	n.mutex.Unlock()
+
+	for _, b := range blocks {
+		n.AddBlock(b)
+	}
+}
+
+// 设置合约脚本, kip为B20或B721
+func (n *Node) SetScript(kid, kip string, abi interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.scripts[kid] = Script{Abi: abi, Bip: kip}
+}
+
+func (n *Node) SetToken(kid string, t Token) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.tokens[kid] = t
+}
+
+func (n *Node) SetTokenUri(kid, tokenId, uri string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.uris[kid+"/"+tokenId] = uri
+}
+
+// 设置ord_call的返回值, params与请求中的params按JSON比较
+func (n *Node) SetCall(kid, method string, params, result interface{}) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.calls[callKey(kid, method, params)] = result
+}
+
+func callKey(kid, method string, params interface{}) string {
+	data, _ := json.Marshal(params)
+	return kid + "/" + method + "/" + string(data)
+}
+
+// 接下来count次调用method时返回错误, status为0时返回JSON-RPC错误, 否则返回该HTTP状态码.
+// method为空时对所有方法生效
+func (n *Node) Fail(method string, count, status int) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.faults[method] = &fault{count: count, status: status}
+}
+
+// 每个请求的延迟
+func (n *Node) SetLatency(d time.Duration) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.latency = d
+}
+
+// method被请求的次数
+func (n *Node) Requests(method string) int {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.requests[method]
+}
+
+type response struct {
+	JSONRPC string                `json:"jsonrpc"`
+	Result  *result               `json:"result,omitempty"`
+	Error   *jsonrpc.JSONRPCError `json:"error,omitempty"`
+	ID      string                `json:"id"`
+}
+
+type result struct {
+	Data interface{} `json:"data"`
+}
+
+func (n *Node) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req jsonrpc.JSONRPCRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n.mutex.Lock()
+	n.requests[req.Method]++
+	latency := n.latency
+	f := n.fault(req.Method)
+	n.mutex.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	resp := response{JSONRPC: "2.0", ID: req.ID}
+	switch {
+	case f != nil && f.status != 0:
+		http.Error(w, "injected failure", f.status)
+		return
+	case f != nil:
+		resp.Error = &jsonrpc.JSONRPCError{Code: -32000, Message: "injected failure"}
+	default:
+		data, err := n.handle(req.Method, req.Params)
+		if err != nil {
+			resp.Error = &jsonrpc.JSONRPCError{Code: -32602, Message: err.Error()}
+		} else {
+			resp.Result = &result{Data: data}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// 取出一次注入的故障
+func (n *Node) fault(method string) *fault {
+	for _, key := range []string{method, ""} {
+		f := n.faults[key]
+		if f == nil || f.count <= 0 {
+			continue
+		}
+		f.count--
+		return f
+	}
+	return nil
+}
+
+func (n *Node) handle(method string, params json.RawMessage) (interface{}, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	switch method {
+	case "bestBlockNumber":
+		return n.best(), nil
+	case "getEvents":
+		var p jsonrpc.EventParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		b, err := n.block(p.Number)
+		if err != nil || b == nil || len(b.Events) == 0 {
+			return nil, err
+		}
+		return b.Events, nil
+	case "getBlockNumber":
+		var p jsonrpc.BlockNumberParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		b, err := n.block(p.Number)
+		if err != nil {
+			return nil, err
+		}
+		if b == nil {
+			return []jsonrpc.Transaction{}, nil
+		}
+		return append([]jsonrpc.Transaction{}, b.Transactions...), nil
+	case "getTransaction":
+		var p jsonrpc.TransactionParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range n.blocks {
+			for _, t := range b.Transactions {
+				if t.TxHash == p.Hash {
+					return t, nil
+				}
+			}
+		}
+		return nil, nil
+	case "getScriptModel":
+		var p jsonrpc.ScriptParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := n.scripts[p.KID]
+		if !ok {
+			return nil, nil
+		}
+		return s, nil
+	case "getTokenModel":
+		var p jsonrpc.TokenParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		t, ok := n.tokens[p.KID]
+		if !ok {
+			return nil, nil
+		}
+		return t, nil
+	case "getTokenUri":
+		var p jsonrpc.TokenUriParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		uri, ok := n.uris[p.KID+"/"+p.TokenId]
+		if !ok {
+			return nil, nil
+		}
+		return uri, nil
+	case "ord_call":
+		var p jsonrpc.CallParam
+		err := json.Unmarshal(params, &p)
+		if err != nil {
+			return nil, err
+		}
+		return n.calls[callKey(p.KID, p.Method, p.Params)], nil
+	}
+	return nil, fmt.Errorf("method %s not found", method)
+}
+
+// 按高度取区块, 超出链范围时返回nil
+func (n *Node) block(number string) (*Block, error) {
+	height, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	i := height - n.start - 1
+	if i < 0 || i >= int64(len(n.blocks)) {
+		return nil, nil
+	}
+	return &n.blocks[i], nil
+}
+
+// B20转账事件
+func Transfer20(kid, from, to string, amount float64) jsonrpc.Event {
+	return jsonrpc.Event{
+		KID:  kid,
+		Name: "Transfer",
+		Args: map[string]interface{}{
+			"from":   from,
+			"to":     to,
+			"amount": strconv.FormatFloat(amount, 'f', -1, 64),
+		},
+	}
+}
+
+// B721转账事件
+func Transfer721(kid, from, to, tokenId string) jsonrpc.Event {
+	return jsonrpc.Event{
+		KID:  kid,
+		Name: "Transfer",
+		Args: map[string]interface{}{
+			"from":    from,
+			"to":      to,
+			"tokenId": tokenId,
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package mocknode
+
+import (
+	"holders/jsonrpc"
+	"testing"
+)
+
+func TestNode(t *testing.T) {
+	n := New(100)
+	defer n.Close()
+	n.SetScript("bit1", "B20", nil)
+	n.AddEvents(Transfer20("bit1", "a", "b", 10))
+	n.AddEvents()
+
+	cli, err := jsonrpc.NewClientWithOptions(n.URL(), jsonrpc.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	best, err := cli.BestBlockNumber()
+	if err != nil || best.(int64) != 102 {
+		t.Fatalf("best block number = %v, %v, want 102", best, err)
+	}
+	events, err := cli.GetEvents(jsonrpc.EventParam{Number: "101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := events.([]jsonrpc.Event)
+	if len(list) != 1 || list[0].Height != 101 || list[0].Args["amount"] != "10" {
+		t.Fatalf("events = %+v", list)
+	}
+	script, err := cli.GetScriptModel(jsonrpc.ScriptParam{KID: "bit1"})
+	if err != nil || script.(*jsonrpc.Script).Kip != "B20" {
+		t.Fatalf("script = %v, %v", script, err)
+	}
+	_, err = cli.GetScriptModel(jsonrpc.ScriptParam{KID: "bit2"})
+	if err != jsonrpc.ErrNotFind {
+		t.Fatalf("unknown script error = %v, want %v", err, jsonrpc.ErrNotFind)
+	}
+
+	//分叉后101高度的转账被替换
+	n.Reorg(2, Block{Events: []jsonrpc.Event{Transfer20("bit1", "a", "c", 5)}})
+	if best := n.Best(); best != 101 {
+		t.Fatalf("best after reorg = %d, want 101", best)
+	}
+	events, err = cli.GetEvents(jsonrpc.EventParam{Number: "101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := events.([]jsonrpc.Event); len(list) != 1 || list[0].Args["to"] != "c" {
+		t.Fatalf("events after reorg = %+v", list)
+	}
+
+	n.Fail("getEvents", 1, 0)
+	_, err = cli.GetEvents(jsonrpc.EventParam{Number: "101"})
+	if err == nil {
+		t.Fatal("expected injected error")
+	}
+	_, err = cli.GetEvents(jsonrpc.EventParam{Number: "101"})
+	if err != nil {
+		t.Fatalf("error after injected failure = %v", err)
+	}
+	if got := n.Requests("getEvents"); got != 4 {
+		t.Errorf("getEvents requests = %d, want 4", got)
+	}
+}
@@ -1,11 +1,17 @@
 package models
 
+import "time"
 
 type Transfer20 struct {
 	Kid    string  `json:"kid"`
 	From   string  `json:"from"`
 	To     string  `json:"to"`
 	Amount float64 `json:"amount"`
+
+	// 来源事件
+	Height int64  `json:"height" mapstructure:"-"`
+	TxHash string `json:"txHash" mapstructure:"-"`
+	EHash  string `json:"eHash" mapstructure:"-"`
 }
 
 type Transfer721 struct {
@@ -14,6 +20,11 @@ type Transfer721 struct {
 	To      string      `json:"to"`
 	TokenId interface{} `json:"tokenId"`
 	Data    string      `json:"data"`
+
+	// 来源事件
+	Height int64  `json:"height" mapstructure:"-"`
+	TxHash string `json:"txHash" mapstructure:"-"`
+	EHash  string `json:"eHash" mapstructure:"-"`
 }
 
 type Balance20 struct {
@@ -65,3 +76,63 @@ type Result struct {
 	Data interface{} `json:"data"`
 	Msg  interface{} `json:"msg"`
 }
+
+// 各链已写入数据库的区块高度, 与区块数据在同一事务中更新
+type Cursor struct {
+	Chain  string `json:"chain" gorm:"primaryKey;size:128"`
+	Number int64  `json:"number"`
+}
+
+// 转账异常记录, 发送地址没有余额或余额不足时写入, 与区块数据在同一事务中
+type Anomaly struct {
+	Id      int64   `json:"id" gorm:"primaryKey;autoIncrement"`
+	Chain   string  `json:"chain" gorm:"size:128;index"`
+	Height  int64   `json:"height" gorm:"index"`
+	TxHash  string  `json:"txHash"`
+	EHash   string  `json:"eHash"`
+	Kid     string  `json:"kid" gorm:"size:128;index"`
+	Owner   string  `json:"owner" gorm:"size:128;index"`
+	Kind    string  `json:"kind"`
+	Policy  string  `json:"policy"`
+	Balance float64 `json:"balance"`
+	Amount  float64 `json:"amount"`
+	// 按reject策略拒绝, 转账未写入
+	Rejected  bool      `json:"rejected"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// 余额变更流水, 每笔转账写入的每项变更对应一行, 可按相反顺序撤销
+type Journal struct {
+	Id     int64  `json:"id" gorm:"primaryKey;autoIncrement"`
+	Chain  string `json:"chain" gorm:"size:128;index:idx_journal_height,priority:1"`
+	Height int64  `json:"height" gorm:"index:idx_journal_height,priority:2"`
+	TxHash string `json:"txHash"`
+	EHash  string `json:"eHash"`
+	// b20余额, b721所有权或hold持有记录
+	Kind string `json:"kind" gorm:"size:16"`
+	// insert, update或delete
+	Op    string `json:"op" gorm:"size:16"`
+	Kid   string `json:"kid" gorm:"size:128;index"`
+	Owner string `json:"owner" gorm:"size:128;index"`
+	// b20的变更量和变更后的余额, 删除时余额为0
+	Delta   float64 `json:"delta"`
+	Balance float64 `json:"balance"`
+	// b721的tokenId和变更前的所有者
+	TokenId   string `json:"tokenId"`
+	PrevOwner string `json:"prevOwner" gorm:"size:128;index"`
+	// hold的合约标准
+	Bip int `json:"bip"`
+}
+
+// 地址的一条余额变更, b721转出时Delta为-1
+type JournalEntry struct {
+	Id      int64   `json:"id"`
+	Height  int64   `json:"height"`
+	TxHash  string  `json:"txHash"`
+	EHash   string  `json:"eHash"`
+	Kid     string  `json:"kid"`
+	Bip     int     `json:"bip"`
+	Delta   float64 `json:"delta"`
+	Balance float64 `json:"balance,omitempty"`
+	TokenId string  `json:"tokenId,omitempty"`
+}
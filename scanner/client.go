@@ -1,44 +1,127 @@
 package scanner
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"holders/conf"
 	"holders/db"
 	"holders/jsonrpc"
-	"log"
+	"holders/metrics"
+	"holders/tracing"
+	"log/slog"
+	"sync"
 	"time"
 )
 
+// 队列为空或处理失败时的等待时间
+const queueRetryInterval = 5 * time.Second
+
 type rpc struct {
 	client *jsonrpc.Client
 	chain  string
-	eChan  chan []jsonrpc.Event
+	// 新事件入队通知
+	eChan chan struct{}
+	// 节点新区块推送通知
+	bChan chan struct{}
+	// 推送是否已连接
+	push bool
+
+	// ResolveLogs运行期间有效, 用于启动重建任务
+	ctx context.Context
+	wg  sync.WaitGroup
+	// 区块写入与重建任务交接时互斥
+	commitMutex sync.Mutex
+	mutex       sync.Mutex
+	reindexes   map[string]*ReindexStatus
+	// 已拉取未写入区块的span
+	traces map[int64]trace.SpanContext
+	// 未达到确认数的区块
+	pending *PendingView
+	// 正在获取信息的合约
+	metas map[string]bool
+	// 该链的索引范围
+	filter *Filter
+
+	stats stats
 }
 
+var (
+	clients      = make(map[string]*rpc)
+	clientsMutex sync.Mutex
+)
+
 func NewClient(url, chain string) (*rpc, error) {
 	cli, err := jsonrpc.NewClient(url)
 	if err != nil {
 		return nil, err
 	}
-	return &rpc{client: cli, chain: chain, eChan: make(chan []jsonrpc.Event)}, nil
+	p := conf.ProfileOf(chain)
+	filter, err := ParseFilter(p.Kids, p.IgnoreKids, p.Kips, p.IgnoreKips)
+	if err != nil {
+		return nil, err
+	}
+	db.SetPartialKids(chain, filter.partialKids())
+	r := &rpc{
+		client:    cli,
+		chain:     chain,
+		eChan:     make(chan struct{}, 1),
+		bChan:     make(chan struct{}, 1),
+		reindexes: make(map[string]*ReindexStatus),
+		traces:    make(map[int64]trace.SpanContext),
+		metas:     make(map[string]bool),
+		filter:    filter,
+	}
+
+	clientsMutex.Lock()
+	clients[chain] = r
+	clientsMutex.Unlock()
+	return r, nil
+}
+
+// 获取本进程中某条链的扫描客户端, 未启动扫描时返回nil
+func GetClient(chain string) *rpc {
+	clientsMutex.Lock()
+	defer clientsMutex.Unlock()
+	return clients[chain]
 }
 
-func (r *rpc) FilterLogs() {
+// 扫描区块事件写入队列, ctx取消后停止扫描.
+// 链配置了NodeWS时同时订阅新区块推送, 轮询作为兜底
+func (r *rpc) FilterLogs(ctx context.Context) {
 	scanNumber := int64(0)
 
-	for {
+	if ws := conf.ProfileOf(r.chain).NodeWS; ws != "" {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.subscribe(ctx, ws)
+		}()
+		defer wg.Wait()
+	}
+
+	var p poller
+	for ctx.Err() == nil {
 		number, err := r.client.BestBlockNumber()
 		if err != nil {
-			log.Println(err)
+			d := p.backoff()
+			r.fail("get best block number failed", err, "retry_in", d)
+			sleep(ctx, d)
 			continue
 		}
 		fistNumber := db.FistNumber(r.chain)
-		lastNumber := number.(int64)
+		best := number.(int64)
+		r.setBest(best)
+		//只扫描达到确认数的区块
+		lastNumber := best - conf.Confirmations
 		localNumber := int64(fistNumber)
 
 		if localNumber == 0 {
 			//协议运行区块 - 1
-			localNumber = conf.StartNumber
+			localNumber = conf.ProfileOf(r.chain).StartNumber
 		}
 
 		////模拟需要同步的区块
@@ -56,37 +139,205 @@ func (r *rpc) FilterLogs() {
 				scanNumber = localNumber + 1
 			}
 
-			fmt.Println("indexed number is ",scanNumber)
+			slog.Debug("scanning", "chain", r.chain, "height", scanNumber, "best", lastNumber)
 
-			param := jsonrpc.EventParam{
-				Number: fmt.Sprint(scanNumber),
-			}
-			events, err := r.client.GetEvents(param)
+			err = r.scanHeight(ctx, scanNumber)
 			if err != nil {
-				fmt.Println(err)
+				sleep(ctx, p.backoff())
 				continue
 			}
-			if events != nil {
-				eventList := events.([]jsonrpc.Event)
-				if eventList != nil {
-					r.eChan <- eventList
+			p.progress()
+			metrics.HeightsScanned.WithLabelValues(r.chain).Inc()
+			r.prunePending(scanNumber)
+		} else {
+			//未确认视图从已写入数据库的高度开始, 覆盖已入队未写入的区块,
+			//已写入高度或最新高度变化时才重建
+			if conf.Pending && conf.Confirmations > 0 {
+				applied, err := db.AppliedNumber(r.chain)
+				if err != nil {
+					r.fail("read applied cursor failed", err)
+				} else if !r.pendingAt(applied, best) {
+					r.prunePending(applied)
+					err = r.refreshPending(ctx, applied, best)
+					if err != nil {
+						r.fail("refresh pending blocks failed", err, "from", applied, "to", best)
+					}
 				}
 			}
+			select {
+			case <-ctx.Done():
+			case <-r.bChan:
+				p.progress()
+			case <-time.After(p.wait(r.pushing())):
+			}
+		}
+	}
+}
 
-			err = db.WriteNumber(r.chain, param.Number)
+// 拉取单个高度的事件写入队列, 有事件时记录span以便写入时关联
+func (r *rpc) scanHeight(ctx context.Context, scanNumber int64) (err error) {
+	ctx, span := tracing.Start(ctx, "scan.height",
+		attribute.String("chain", r.chain), attribute.Int64("height", scanNumber))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	param := jsonrpc.EventParam{
+		Number: fmt.Sprint(scanNumber),
+	}
+	events, err := r.client.WithContext(ctx).GetEvents(param)
+	if err != nil {
+		r.fail("get events failed", err, "height", scanNumber)
+		return err
+	}
+
+	//事件先写入持久化队列, 与游标一起提交
+	var data []byte
+	if events != nil {
+		eventList := events.([]jsonrpc.Event)
+		if eventList != nil {
+			data, err = json.Marshal(eventList)
 			if err != nil {
-				fmt.Println(err)
+				r.fail("encode events failed", err, "height", scanNumber)
+				return err
 			}
-		} else {
-			time.Sleep(1 * time.Minute)
+		}
+	}
+	err = db.EnqueueEvents(r.chain, scanNumber, data)
+	if err != nil {
+		r.fail("enqueue events failed", err, "height", scanNumber)
+		return err
+	}
+	if data != nil {
+		span.SetAttributes(attribute.Int("events", len(events.([]jsonrpc.Event))))
+		r.saveTrace(scanNumber, span.SpanContext())
+		select {
+		case r.eChan <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// 按合约将事件分发到多个worker并行解析, 区块在所有分片完成后按顺序整体写入.
+// ctx取消后不再分发新的区块, 等待已解析完成的区块写入后返回
+func (r *rpc) ResolveLogs(ctx context.Context) {
+	workers := conf.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	p := newProgress()
+	shards := make([]chan job, workers)
+	var wg sync.WaitGroup
+	r.initWorkers(workers)
+	for i := range shards {
+		shards[i] = make(chan job, conf.ResolveWindow)
+		wg.Add(1)
+		go func(id int, jobs <-chan job) {
+			defer wg.Done()
+			r.worker(ctx, id, jobs, p)
+		}(i, shards[i])
+	}
+	committed := make(chan struct{})
+	go func() {
+		defer close(committed)
+		r.commit(ctx, p)
+	}()
+
+	defer func() {
+		for _, jobs := range shards {
+			close(jobs)
+		}
+		wg.Wait()
+		close(p.ready)
+		<-committed
+		r.wg.Wait()
+	}()
+
+	//恢复重启前未完成的重建任务
+	r.mutex.Lock()
+	r.ctx = ctx
+	r.mutex.Unlock()
+	r.resumeReindex()
+	//为新加入索引范围的合约补齐数据
+	r.applyFilter()
+
+	//重启前已写入数据库但未确认的区块
+	applied, err := db.AppliedNumber(r.chain)
+	if err != nil {
+		r.fail("read applied cursor failed", err)
+	}
+
+	var last int64
+	for ctx.Err() == nil {
+		limit := conf.ResolveWindow - p.outstanding()
+		if limit <= 0 {
+			select {
+			case <-ctx.Done():
+			case <-p.freed:
+			case <-time.After(queueRetryInterval):
+			}
+			continue
+		}
+
+		entries, err := db.ScanQueue(r.chain, last, limit)
+		if err != nil {
+			r.fail("read queue failed", err)
+		}
+		if len(entries) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-r.eChan:
+			case <-p.freed:
+			case <-time.After(queueRetryInterval):
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			last = entry.Height
+			if entry.Height <= applied {
+				err = db.AckQueue(r.chain, entry.Height)
+				if err != nil {
+					r.fail("ack queue failed", err, "height", entry.Height)
+				}
+				continue
+			}
+			r.dispatch(entry, shards, p)
 		}
 	}
 }
 
-func (r *rpc) ResolveLogs() {
-	for events := range r.eChan {
-		for _, e := range events {
-			transfer(e)
+// 将一个区块的事件按合约分片
+func (r *rpc) dispatch(entry db.QueueEntry, shards []chan job, p *progress) {
+	b := &block{height: entry.Height, data: entry.Data}
+	//区块span作为拉取span的子span, 从拉取到写入在同一条trace中
+	parent := trace.ContextWithSpanContext(context.Background(), r.loadTrace(entry.Height))
+	b.ctx, b.span = tracing.Start(parent, "block.apply",
+		attribute.String("chain", r.chain), attribute.Int64("height", entry.Height))
+
+	var events []jsonrpc.Event
+	err := json.Unmarshal(entry.Data, &events)
+	if err != nil {
+		//无法解析的数据重试也无法成功, 直接跳过
+		r.fail("decode queued events failed, height skipped", err, "height", entry.Height)
+		p.add(b, 0)
+		return
+	}
+	b.events = len(events)
+	b.span.SetAttributes(attribute.Int("events", b.events))
+
+	jobs := make(map[int]*job)
+	for i, e := range events {
+		n := shardOf(e.KID, len(shards))
+		if jobs[n] == nil {
+			jobs[n] = &job{ctx: b.ctx, height: entry.Height}
 		}
+		jobs[n].events = append(jobs[n].events, indexedEvent{index: i, event: e})
+	}
+
+	p.add(b, len(jobs))
+	for n, j := range jobs {
+		shards[n] <- *j
 	}
 }
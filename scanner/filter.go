@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"log/slog"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// 索引范围: 只写入允许的合约和协议的转账, 每个合约可以有自己的起始高度
+type Filter struct {
+	// 只索引这些合约, 值为起始高度, 0表示从链的起始高度开始. 为空时索引全部合约
+	Kids map[string]int64 `json:"kids,omitempty"`
+	// 不索引的合约
+	IgnoreKids map[string]bool `json:"ignoreKids,omitempty"`
+	// 只索引这些协议, 如B20, B721. 为空时索引全部协议
+	Kips map[string]bool `json:"kips,omitempty"`
+	// 不索引的协议
+	IgnoreKips map[string]bool `json:"ignoreKips,omitempty"`
+}
+
+// 解析索引范围, kids的每一项为 kid 或 kid@起始高度. 每条链的索引范围在链配置中设置
+func ParseFilter(kids, ignoreKids, kips, ignoreKips []string) (*Filter, error) {
+	f := &Filter{}
+	for _, v := range kids {
+		kid, height, found := strings.Cut(v, "@")
+		var start int64
+		if found {
+			n, err := strconv.ParseInt(height, 10, 64)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid start height in %q", v)
+			}
+			start = n
+		}
+		if f.Kids == nil {
+			f.Kids = make(map[string]int64)
+		}
+		f.Kids[kid] = start
+	}
+	f.IgnoreKids = toSet(ignoreKids)
+	f.Kips = toSet(kips)
+	f.IgnoreKips = toSet(ignoreKips)
+	for kid := range f.Kids {
+		if f.IgnoreKids[kid] {
+			return nil, fmt.Errorf("%s is both tracked and ignored", kid)
+		}
+	}
+	return f, nil
+}
+
+func toSet(values []string) map[string]bool {
+	if len(values) == 0 {
+		return nil
+	}
+	set := make(map[string]bool)
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// 合约从哪个高度开始索引, 不索引时ok为false. def为链的起始高度
+func (f *Filter) start(kid string, def int64) (int64, bool) {
+	if f.IgnoreKids[kid] {
+		return 0, false
+	}
+	if len(f.Kids) > 0 {
+		start, ok := f.Kids[kid]
+		if !ok {
+			return 0, false
+		}
+		if start > 0 {
+			return start, true
+		}
+	}
+	return def, true
+}
+
+// 配置了起始高度的合约, 没有起始高度之前的余额
+func (f *Filter) partialKids() []string {
+	var kids []string
+	for kid, start := range f.Kids {
+		if start > 0 {
+			kids = append(kids, kid)
+		}
+	}
+	return kids
+}
+
+// 是否索引该合约在height的事件
+func (f *Filter) tracks(kid string, height int64) bool {
+	start, ok := f.start(kid, 0)
+	return ok && height >= start
+}
+
+// 是否索引该协议
+func (f *Filter) tracksKip(kip string) bool {
+	if f.IgnoreKips[kip] {
+		return false
+	}
+	return len(f.Kips) == 0 || f.Kips[kip]
+}
+
+// 与上次启动时的索引范围比较, 为新加入或起始高度变化的合约启动重建任务, 从其起始高度补齐数据,
+// 主扫描游标不受影响. 不再索引的合约保留已写入的数据
+func (r *rpc) applyFilter() {
+	data, err := db.GetFilter(r.chain)
+	if err != nil {
+		r.fail("read filter failed", err)
+		return
+	}
+	//没有保存过时, 之前索引全部合约
+	prev := &Filter{}
+	if data != nil {
+		err = json.Unmarshal(data, prev)
+		if err != nil {
+			r.fail("decode filter failed", err)
+			return
+		}
+	}
+
+	filter := r.filter
+	def := conf.ProfileOf(r.chain).StartNumber + 1
+	kids := make(map[string]bool)
+	for _, f := range []*Filter{prev, filter} {
+		for kid := range f.Kids {
+			kids[kid] = true
+		}
+		for kid := range f.IgnoreKids {
+			kids[kid] = true
+		}
+	}
+	for kid := range kids {
+		start, ok := filter.start(kid, def)
+		prevStart, prevOk := prev.start(kid, def)
+		if !ok {
+			if prevOk {
+				slog.Info("contract is no longer indexed, data kept", "chain", r.chain, "kid", kid)
+			}
+			continue
+		}
+		if prevOk && prevStart == start || r.reindexing(kid) {
+			continue
+		}
+		_, err = r.Reindex(kid, start)
+		if err != nil {
+			r.fail("start backfill failed", err, "kid", kid, "from", start)
+			return
+		}
+		slog.Info("backfill started", "chain", r.chain, "kid", kid, "from", start)
+	}
+
+	//之前因不在合约列表中或协议被排除的合约无法列举, 需要单独重建
+	if len(prev.Kids) > 0 && len(filter.Kids) == 0 || !maps.Equal(prev.Kips, filter.Kips) || !maps.Equal(prev.IgnoreKips, filter.IgnoreKips) {
+		slog.Warn("filter changed, contracts excluded before are not backfilled automatically, reindex them if needed", "chain", r.chain)
+	}
+
+	data, err = json.Marshal(filter)
+	if err == nil {
+		err = db.PutFilter(r.chain, data)
+	}
+	if err != nil {
+		r.fail("save filter failed", err)
+	}
+}
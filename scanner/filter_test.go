@@ -0,0 +1,46 @@
+package scanner
+
+import "testing"
+
+func TestFilter(t *testing.T) {
+	f, err := ParseFilter([]string{"k1", "k2@100"}, nil, nil, []string{"B721"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		kid    string
+		height int64
+		want   bool
+	}{
+		{"k1", 1, true},
+		{"k2", 99, false},
+		{"k2", 100, true},
+		{"k3", 100, false},
+	}
+	for _, c := range cases {
+		if got := f.tracks(c.kid, c.height); got != c.want {
+			t.Errorf("tracks(%s, %d) = %v, want %v", c.kid, c.height, got, c.want)
+		}
+	}
+	if !f.tracksKip("B20") || f.tracksKip("B721") {
+		t.Errorf("kip filter = %+v", f)
+	}
+
+	//没有合约列表时只排除忽略的合约
+	f, err = ParseFilter(nil, []string{"k1"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.tracks("k1", 1) || !f.tracks("k2", 1) {
+		t.Errorf("ignore filter = %+v", f)
+	}
+
+	for _, kids := range [][]string{{"k1@0"}, {"k1@x"}} {
+		if _, err := ParseFilter(kids, nil, nil, nil); err == nil {
+			t.Errorf("ParseFilter(%v) should fail", kids)
+		}
+	}
+	if _, err := ParseFilter([]string{"k1"}, []string{"k1"}, nil, nil); err == nil {
+		t.Error("tracked and ignored kid should fail")
+	}
+}
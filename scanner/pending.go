@@ -0,0 +1,131 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"holders/jsonrpc"
+	"holders/models"
+)
+
+// 未达到确认数的区块上的转账, 只保存在内存中, 不写入数据库
+type PendingView struct {
+	// 覆盖的区块范围 (From, To]
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+	// kid -> owner -> 余额变化
+	B20 map[string]map[string]float64 `json:"b20"`
+	// kid -> tokenId -> 最后一次转移
+	B721 map[string]map[string]models.Transfer721 `json:"b721"`
+}
+
+func newPendingView(from, to int64) *PendingView {
+	return &PendingView{
+		From: from,
+		To:   to,
+		B20:  make(map[string]map[string]float64),
+		B721: make(map[string]map[string]models.Transfer721),
+	}
+}
+
+// 按区块顺序叠加一笔转账
+func (v *PendingView) add(t interface{}) {
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		if transfer.From == transfer.To || transfer.Amount < 0 {
+			return
+		}
+		m := v.B20[transfer.Kid]
+		if m == nil {
+			m = make(map[string]float64)
+			v.B20[transfer.Kid] = m
+		}
+		m[transfer.From] -= transfer.Amount
+		m[transfer.To] += transfer.Amount
+	case models.Transfer721:
+		if transfer.From == transfer.To {
+			return
+		}
+		m := v.B721[transfer.Kid]
+		if m == nil {
+			m = make(map[string]models.Transfer721)
+			v.B721[transfer.Kid] = m
+		}
+		m[fmt.Sprint(transfer.TokenId)] = transfer
+	}
+}
+
+// owner在未确认区块中涉及的合约
+func (v *PendingView) Kids(owner string) map[string]int {
+	kids := make(map[string]int)
+	for kid, m := range v.B20 {
+		if _, ok := m[owner]; ok {
+			kids[kid] = 20
+		}
+	}
+	for kid, m := range v.B721 {
+		for _, t := range m {
+			if t.From == owner || t.To == owner {
+				kids[kid] = 721
+				break
+			}
+		}
+	}
+	return kids
+}
+
+// 当前的未确认视图, 未开启或尚未生成时返回nil
+func (r *rpc) Pending() *PendingView {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.pending
+}
+
+// 重新拉取已确认高度之后的区块, 生成新的未确认视图.
+// 没有区块哈希无法判断分叉, 因此每次都完整重建
+func (r *rpc) refreshPending(ctx context.Context, confirmed, best int64) error {
+	v := newPendingView(confirmed, best)
+	cli := r.client.WithContext(ctx)
+	for height := confirmed + 1; height <= best; height++ {
+		param := jsonrpc.EventParam{
+			Number: fmt.Sprint(height),
+		}
+		events, err := cli.GetEvents(param)
+		if err != nil {
+			return err
+		}
+		if events == nil {
+			continue
+		}
+		for _, e := range events.([]jsonrpc.Event) {
+			//未确认区块可能被回滚, 解析时不写入缓存
+			t, _, err := r.decode(ctx, e, false)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				v.add(t)
+			}
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pending = v
+	return nil
+}
+
+// 清除已确认区块的未确认视图, 刷新失败时避免继续返回过期数据
+func (r *rpc) prunePending(confirmed int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.pending != nil && r.pending.From < confirmed {
+		r.pending = nil
+	}
+}
+
+// 未确认视图是否已覆盖 (from, to]
+func (r *rpc) pendingAt(from, to int64) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.pending != nil && r.pending.From == from && r.pending.To == to
+}
@@ -0,0 +1,131 @@
+package scanner
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"holders/conf"
+	"holders/jsonrpc"
+	"log/slog"
+	"time"
+)
+
+// 扫描间隔: 接近最新高度时短间隔轮询, 没有新区块时逐渐放慢, 出错时指数退避
+type poller struct {
+	idle     time.Duration
+	failures int
+}
+
+// 扫描到新区块, 下次立即从最短间隔开始
+func (p *poller) progress() {
+	p.idle = 0
+	p.failures = 0
+}
+
+// 已追上最新高度时的等待时间, push为true时只作为推送的兜底
+func (p *poller) wait(push bool) time.Duration {
+	p.failures = 0
+	if push {
+		return conf.PollMaxInterval
+	}
+	if p.idle == 0 {
+		p.idle = conf.PollInterval
+	} else {
+		p.idle *= 2
+	}
+	if p.idle > conf.PollMaxInterval {
+		p.idle = conf.PollMaxInterval
+	}
+	return p.idle
+}
+
+// 请求失败后的等待时间
+func (p *poller) backoff() time.Duration {
+	d := conf.PollInterval << p.failures
+	if d <= 0 || d > conf.BackoffMaxInterval {
+		d = conf.BackoffMaxInterval
+	} else {
+		p.failures++
+	}
+	return d
+}
+
+// 订阅节点的新区块推送, 连接断开后按退避间隔重连, 期间由轮询兜底
+func (r *rpc) subscribe(ctx context.Context, url string) {
+	var p poller
+	for ctx.Err() == nil {
+		err := r.listen(ctx, url, &p)
+		if ctx.Err() != nil {
+			return
+		}
+		r.setPush(false)
+		d := p.backoff()
+		r.fail("block subscription failed", err, "retry_in", d)
+		sleep(ctx, d)
+	}
+}
+
+// 建立一次连接并等待推送, 收到任意消息都视为有新区块
+func (r *rpc) listen(ctx context.Context, url string, p *poller) error {
+	//与节点请求使用相同的鉴权, 代理和证书
+	o := jsonrpc.DefaultOptions()
+	proxy, err := o.ProxyFunc()
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return err
+	}
+	dialer := websocket.Dialer{
+		Proxy:            proxy,
+		TLSClientConfig:  tlsConfig,
+		HandshakeTimeout: websocket.DefaultDialer.HandshakeTimeout,
+	}
+	conn, _, err := dialer.DialContext(ctx, url, o.Header())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	//ctx取消时关闭连接, 结束阻塞的读取
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if conf.NodeWSSubscribe != "" {
+		err = conn.WriteMessage(websocket.TextMessage, []byte(conf.NodeWSSubscribe))
+		if err != nil {
+			return err
+		}
+	}
+	slog.Info("block subscription connected", "chain", r.chain)
+	r.setPush(true)
+
+	for received := false; ; received = true {
+		_, _, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		//收到第一条消息后才重置退避, 连接后立即断开的节点按退避间隔重连
+		if !received {
+			p.progress()
+		}
+		select {
+		case r.bChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (r *rpc) setPush(push bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.push = push
+}
+
+// 是否已连接推送
+func (r *rpc) pushing() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.push
+}
@@ -0,0 +1,138 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"holders/models"
+	"holders/tools"
+	"math"
+)
+
+// 索引数据与链上数据不一致的记录
+type Mismatch struct {
+	Owner   string `json:"owner"`
+	TokenId string `json:"tokenId,omitempty"`
+	Method  string `json:"method"`
+	Indexed string `json:"indexed"`
+	OnChain string `json:"onChain"`
+	Error   string `json:"error,omitempty"`
+}
+
+// 对账结果
+type ReconcileReport struct {
+	Kid        string     `json:"kid"`
+	Bip        int        `json:"bip"`
+	Height     int64      `json:"height"`
+	Checked    int        `json:"checked"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+// 通过ord_call在已索引高度上调用$balanceOf/$ownerOf, 与余额表逐条对比.
+// 已索引高度和余额在同一个快照中读取, 扫描进程运行时也不会误报. sample<=0时检查全部持有人
+func Reconcile(chain, kid string, sample int) (*ReconcileReport, error) {
+	cli, err := jsonrpc.NewClient(conf.ProfileOf(chain).NodeUrl)
+	if err != nil {
+		return nil, err
+	}
+	s, err := db.SampleReconcile(chain, kid, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Kid: kid, Bip: s.Bip, Height: s.Height}
+	c := &reconciler{cli: cli, chain: chain, kid: kid, number: fmt.Sprint(s.Height), report: report}
+	switch report.Bip {
+	case 20:
+		c.check20(s.Balances)
+	case 721:
+		c.check721(s.Tokens, s.Counts)
+	default:
+		return nil, errors.New("token not indexed")
+	}
+	return report, nil
+}
+
+type reconciler struct {
+	cli    *jsonrpc.Client
+	chain  string
+	kid    string
+	number string
+	report *ReconcileReport
+}
+
+func (c *reconciler) call(method string, arg string) (string, error) {
+	result, err := c.cli.CallContract(jsonrpc.CallParam{
+		KID:    c.kid,
+		Method: method,
+		Params: []string{arg},
+		Number: c.number,
+	})
+	if err != nil {
+		return "", err
+	}
+	if result == nil {
+		return "", nil
+	}
+	return fmt.Sprint(result), nil
+}
+
+// 对比数量, 链上返回空视为0
+func sameAmount(indexed float64, onChain string) bool {
+	if onChain == "" {
+		return indexed == 0
+	}
+	f, err := tools.String2Float(onChain)
+	if err != nil {
+		return false
+	}
+	return math.Abs(f-indexed) <= 1e-9*math.Max(1, math.Abs(indexed))
+}
+
+func (c *reconciler) check20(balances []models.Balance20) {
+	for _, b := range balances {
+		c.report.Checked++
+		indexed := tools.Float2String(b.Amount)
+		onChain, err := c.call("$balanceOf", b.Owner)
+		if err != nil {
+			c.mismatch(Mismatch{Owner: b.Owner, Method: "$balanceOf", Indexed: indexed, Error: err.Error()})
+			continue
+		}
+		if !sameAmount(b.Amount, onChain) {
+			c.mismatch(Mismatch{Owner: b.Owner, Method: "$balanceOf", Indexed: indexed, OnChain: onChain})
+		}
+	}
+}
+
+// counts为抽样NFT的所有者持有的NFT数量
+func (c *reconciler) check721(tokens []db.HeldToken, counts map[string]int64) {
+	for _, t := range tokens {
+		c.report.Checked++
+		onChain, err := c.call("$ownerOf", t.TokenId)
+		if err != nil {
+			c.mismatch(Mismatch{Owner: t.Owner, TokenId: t.TokenId, Method: "$ownerOf", Indexed: t.Owner, Error: err.Error()})
+			continue
+		}
+		if onChain != t.Owner {
+			c.mismatch(Mismatch{Owner: t.Owner, TokenId: t.TokenId, Method: "$ownerOf", Indexed: t.Owner, OnChain: onChain})
+		}
+	}
+
+	for owner, count := range counts {
+		indexed := fmt.Sprint(count)
+		onChain, err := c.call("$balanceOf", owner)
+		if err != nil {
+			c.mismatch(Mismatch{Owner: owner, Method: "$balanceOf", Indexed: indexed, Error: err.Error()})
+			continue
+		}
+		if !sameAmount(float64(count), onChain) {
+			c.mismatch(Mismatch{Owner: owner, Method: "$balanceOf", Indexed: indexed, OnChain: onChain})
+		}
+	}
+}
+
+func (c *reconciler) mismatch(m Mismatch) {
+	c.report.Mismatches = append(c.report.Mismatches, m)
+}
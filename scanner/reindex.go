@@ -0,0 +1,310 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"holders/tracing"
+	"log/slog"
+	"time"
+)
+
+// 重建任务状态
+const (
+	ReindexRunning = "running"
+	ReindexDone    = "done"
+)
+
+// 单合约重建进度
+type ReindexStatus struct {
+	Kid       string    `json:"kid"`
+	From      int64     `json:"from"`
+	Current   int64     `json:"current"`
+	Target    int64     `json:"target"`
+	State     string    `json:"state"`
+	LastError string    `json:"lastError,omitempty"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+// 删除某个合约的余额和持有数据, 从from开始重新扫描该合约的事件并重建,
+// 期间主扫描继续运行, 追上主扫描后由主扫描接管. from<=0时从合约首次出现的高度开始,
+// 不早于索引范围中该合约的起始高度
+func (r *rpc) Reindex(kid string, from int64) (ReindexStatus, error) {
+	r.mutex.Lock()
+	ctx := r.ctx
+	st := r.reindexes[kid]
+	running := st != nil && st.State == ReindexRunning
+	r.mutex.Unlock()
+
+	if ctx == nil || ctx.Err() != nil {
+		return ReindexStatus{}, errors.New("scanner is not running")
+	}
+	if running {
+		return ReindexStatus{}, fmt.Errorf("%s is already reindexing", kid)
+	}
+
+	from, err := reindexFrom(r.chain, r.filter, kid, from)
+	if err != nil {
+		return ReindexStatus{}, err
+	}
+
+	//标记后主扫描不再写入该合约, 再删除旧数据
+	r.commitMutex.Lock()
+	r.startReindex(kid, from)
+	err = db.SaveReindex(r.chain, kid, from)
+	if err == nil {
+		err = db.DropToken(r.chain, kid)
+	}
+	r.commitMutex.Unlock()
+	if err != nil {
+		r.mutex.Lock()
+		delete(r.reindexes, kid)
+		r.mutex.Unlock()
+		db.DeleteReindex(r.chain, kid)
+		return ReindexStatus{}, err
+	}
+
+	//任务启动后进度由重建协程更新, 返回副本
+	status, _ := r.ReindexStatus(kid)
+	r.wg.Add(1)
+	go r.reindex(ctx, kid)
+	return status, nil
+}
+
+// 重建的起始高度, from<=0时为合约首次出现的高度, 不早于索引范围中该合约的起始高度
+func reindexFrom(chain string, filter *Filter, kid string, from int64) (int64, error) {
+	if from <= 0 {
+		from = db.FirstSeen(chain, kid)
+	}
+	if from <= 0 {
+		from = conf.ProfileOf(chain).StartNumber + 1
+	}
+	//起始高度之前的事件不索引
+	start, ok := filter.start(kid, 0)
+	if !ok {
+		return 0, fmt.Errorf("%s is not indexed", kid)
+	}
+	if from < start {
+		from = start
+	}
+	return from, nil
+}
+
+// 扫描进程停止时登记单合约重建任务并删除该合约的数据, 扫描进程启动后执行. 返回实际的起始高度
+func ScheduleReindex(chain, kid string, from int64) (int64, error) {
+	p := conf.ProfileOf(chain)
+	filter, err := ParseFilter(p.Kids, p.IgnoreKids, p.Kips, p.IgnoreKips)
+	if err != nil {
+		return 0, err
+	}
+	from, err = reindexFrom(chain, filter, kid, from)
+	if err != nil {
+		return 0, err
+	}
+	err = db.SaveReindex(chain, kid, from)
+	if err != nil {
+		return 0, err
+	}
+	err = db.DropToken(chain, kid)
+	if err != nil {
+		return 0, err
+	}
+	return from, nil
+}
+
+// 获取重建进度
+func (r *rpc) ReindexStatus(kid string) (ReindexStatus, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	st, ok := r.reindexes[kid]
+	if !ok {
+		return ReindexStatus{}, false
+	}
+	return *st, true
+}
+
+// 所有重建任务的进度
+func (r *rpc) ReindexList() []ReindexStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var list []ReindexStatus
+	for _, st := range r.reindexes {
+		list = append(list, *st)
+	}
+	return list
+}
+
+// 合约是否正在重建
+func (r *rpc) reindexing(kid string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	st, ok := r.reindexes[kid]
+	return ok && st.State == ReindexRunning
+}
+
+func (r *rpc) startReindex(kid string, from int64) *ReindexStatus {
+	now := time.Now()
+	st := &ReindexStatus{
+		Kid:     kid,
+		From:    from,
+		Current: from - 1,
+		State:   ReindexRunning,
+		Started: now,
+		Updated: now,
+	}
+	r.mutex.Lock()
+	r.reindexes[kid] = st
+	r.mutex.Unlock()
+	return st
+}
+
+// 恢复重启前未完成的重建任务, 旧数据已删除, 从保存的进度继续
+func (r *rpc) resumeReindex() {
+	jobs, err := db.ReindexJobs(r.chain)
+	if err != nil {
+		r.fail("read reindex jobs failed", err)
+		return
+	}
+	for kid, from := range jobs {
+		r.startReindex(kid, from)
+		r.wg.Add(1)
+		go r.reindex(r.ctx, kid)
+	}
+}
+
+func (r *rpc) updateReindex(kid string, fn func(st *ReindexStatus)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	st := r.reindexes[kid]
+	fn(st)
+	st.Updated = time.Now()
+}
+
+// 逐个高度重建合约数据, 每个高度与进度在同一事务中写入
+func (r *rpc) reindex(ctx context.Context, kid string) {
+	defer r.wg.Done()
+
+	st, _ := r.ReindexStatus(kid)
+	key := db.ReindexCursor(r.chain, kid)
+	next := st.From
+	done, err := db.AppliedNumber(key)
+	if err != nil {
+		r.fail("read reindex cursor failed", err, "kid", kid)
+	}
+	if done >= next {
+		next = done + 1
+	}
+
+	attempt := 0
+	for ctx.Err() == nil {
+		applied, err := db.AppliedNumber(r.chain)
+		if err != nil {
+			r.reindexFailed(ctx, kid, err)
+			continue
+		}
+
+		if next > applied {
+			//已追上主扫描, 在写入锁内交接
+			r.commitMutex.Lock()
+			applied, err = db.AppliedNumber(r.chain)
+			if err == nil && next > applied {
+				err = db.DeleteReindex(r.chain, kid)
+				if err == nil {
+					r.updateReindex(kid, func(st *ReindexStatus) {
+						st.State = ReindexDone
+						st.Target = applied
+					})
+					r.commitMutex.Unlock()
+					slog.Info("reindex done", "chain", r.chain, "kid", kid, "height", applied)
+					return
+				}
+			}
+			r.commitMutex.Unlock()
+			if err != nil {
+				r.reindexFailed(ctx, kid, err)
+			}
+			continue
+		}
+
+		err = r.reindexHeight(kid, next)
+		if err != nil {
+			attempt++
+			//与主扫描相同, 按FailPolicy隔离多次失败的高度, 避免重建任务停在该高度
+			if conf.FailPolicy == conf.FailQuarantine && attempt >= conf.MaxRetries {
+				err = r.quarantineReindex(ctx, kid, next, err)
+				if err == nil {
+					attempt = 0
+					next++
+					continue
+				}
+			}
+			r.reindexFailed(ctx, kid, err)
+			continue
+		}
+		attempt = 0
+		height := next
+		r.updateReindex(kid, func(st *ReindexStatus) {
+			st.Current = height
+			st.Target = applied
+		})
+		next++
+	}
+}
+
+// 重建单个高度中该合约的事件
+func (r *rpc) reindexHeight(kid string, height int64) (err error) {
+	ctx, span := tracing.Start(context.Background(), "reindex.height",
+		attribute.String("chain", r.chain), attribute.String("kid", kid), attribute.Int64("height", height))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	param := jsonrpc.EventParam{
+		Number: fmt.Sprint(height),
+	}
+	events, err := r.client.WithContext(ctx).GetEvents(param)
+	if err != nil {
+		return err
+	}
+
+	var transfers []interface{}
+	if events != nil {
+		for _, e := range events.([]jsonrpc.Event) {
+			if e.KID != kid {
+				continue
+			}
+			t, _, err := r.transfer(ctx, e)
+			if err != nil {
+				return err
+			}
+			if t != nil {
+				transfers = append(transfers, t)
+			}
+		}
+	}
+	return db.ApplyBlock(ctx, db.ReindexCursor(r.chain, kid), height, transfers)
+}
+
+// 将重建失败的高度移入隔离区, 隔离区的键为重建进度键, 与主扫描的隔离区分开
+func (r *rpc) quarantineReindex(ctx context.Context, kid string, height int64, cause error) error {
+	key := db.ReindexCursor(r.chain, kid)
+	err := db.Quarantine(key, height, nil, fmt.Sprint(cause))
+	if err != nil {
+		return err
+	}
+	slog.Warn("reindex height quarantined", "chain", r.chain, "kid", kid, "height", height, "err", cause)
+	return db.ApplyBlock(ctx, key, height, nil)
+}
+
+func (r *rpc) reindexFailed(ctx context.Context, kid string, err error) {
+	r.fail("reindex failed", err, "kid", kid)
+	r.updateReindex(kid, func(st *ReindexStatus) {
+		st.LastError = err.Error()
+	})
+	sleep(ctx, queueRetryInterval)
+}
@@ -0,0 +1,97 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"holders/conf"
+	"holders/jsonrpc"
+	"holders/ledger"
+	"holders/mocknode"
+	"path/filepath"
+	"testing"
+)
+
+// 录制模拟节点的响应后断开节点, 回放录制文件并按扫描的解析和余额规则重建状态, 不需要MySQL和LevelDB
+func TestReplayBalances(t *testing.T) {
+	const start = 100
+	node := mocknode.New(start)
+	defer node.Close()
+	node.SetScript("b20", "B20", nil)
+	node.SetScript("b721", "B721", nil)
+	node.AddEvents(
+		mocknode.Transfer20("b20", conf.ZeroAddress, "alice", 100),
+		mocknode.Transfer721("b721", conf.ZeroAddress, "alice", "1"),
+	)
+	node.AddEvents(
+		mocknode.Transfer20("b20", "alice", "bob", 30),
+		mocknode.Transfer721("b721", "alice", "bob", "1"),
+	)
+	node.AddEvents(
+		mocknode.Transfer20("b20", "bob", "carol", 10.5),
+		//没有余额记录的发送地址, 按默认规则跳过
+		mocknode.Transfer20("b20", "dave", "carol", 5),
+		//不是转账的事件
+		jsonrpc.Event{KID: "b20", Name: "Approval"},
+	)
+
+	file := filepath.Join(t.TempDir(), "node.jsonl")
+	recorded := replay(t, node.URL(), jsonrpc.Options{Record: file}, start, node.Best())
+	node.Close()
+
+	//回放时不访问节点
+	s := replay(t, node.URL(), jsonrpc.Options{Replay: file}, start, node.Best())
+
+	want := map[string]map[string]float64{
+		"b20": {"alice": 70, "bob": 19.5, "carol": 10.5},
+	}
+	if fmt.Sprint(s.Balances) != fmt.Sprint(want) {
+		t.Errorf("balances = %v, want %v", s.Balances, want)
+	}
+	if owner := s.Owners["b721"]["1"]; owner != "bob" {
+		t.Errorf("owner of b721 #1 = %q, want bob", owner)
+	}
+	if fmt.Sprint(recorded) != fmt.Sprint(s) {
+		t.Errorf("replayed state = %+v, recorded %+v", s, recorded)
+	}
+}
+
+// 逐个高度拉取事件, 解析后写入内存状态, 被余额规则拒绝的转账与写入数据库时一样跳过
+func replay(t *testing.T, url string, o jsonrpc.Options, start, best int64) *ledger.MemState {
+	t.Helper()
+	cli, err := jsonrpc.NewClientWithOptions(url, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &rpc{client: cli, chain: conf.Chain, filter: &Filter{}}
+	s := ledger.NewMemState()
+	ctx := context.Background()
+	for height := start + 1; height <= best; height++ {
+		events, err := cli.GetEvents(jsonrpc.EventParam{Number: fmt.Sprint(height)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if events == nil {
+			continue
+		}
+		for _, e := range events.([]jsonrpc.Event) {
+			transfer, _, err := r.decode(ctx, e, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transfer == nil {
+				continue
+			}
+			cs, err := ledger.Apply(s, transfer)
+			var anomaly *ledger.AnomalyError
+			if errors.As(err, &anomaly) {
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Persist(cs)
+		}
+	}
+	return s
+}
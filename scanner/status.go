@@ -0,0 +1,158 @@
+package scanner
+
+import (
+	"holders/conf"
+	"holders/db"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// worker状态
+const (
+	WorkerIdle      = "idle"
+	WorkerResolving = "resolving"
+	WorkerRetrying  = "retrying"
+)
+
+// 计算事件速率的时间窗口
+const rateWindow = time.Minute
+
+type WorkerStatus struct {
+	Id     int       `json:"id"`
+	State  string    `json:"state"`
+	Height int64     `json:"height,omitempty"`
+	Kid    string    `json:"kid,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// 扫描状态
+type Status struct {
+	Chain           string         `json:"chain"`
+	Cursor          int64          `json:"cursor"`
+	Applied         int64          `json:"applied"`
+	Best            int64          `json:"best"`
+	Confirmations   int64          `json:"confirmations"`
+	Lag             int64          `json:"lag"`
+	PendingFrom     int64          `json:"pendingFrom,omitempty"`
+	PendingTo       int64          `json:"pendingTo,omitempty"`
+	EventsPerSecond float64        `json:"eventsPerSecond"`
+	Queue           int            `json:"queue"`
+	LastError       string         `json:"lastError,omitempty"`
+	LastErrorAt     *time.Time     `json:"lastErrorAt,omitempty"`
+	Workers         []WorkerStatus `json:"workers"`
+}
+
+type sample struct {
+	at     time.Time
+	events int
+}
+
+// 运行时统计
+type stats struct {
+	mutex       sync.Mutex
+	best        int64
+	lastError   string
+	lastErrorAt time.Time
+	workers     []WorkerStatus
+	samples     []sample
+}
+
+// 记录并输出错误, args为附加的日志字段
+func (r *rpc) fail(msg string, err error, args ...any) {
+	slog.Error(msg, append([]any{"chain", r.chain, "err", err}, args...)...)
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	r.stats.lastError = msg + ": " + err.Error()
+	r.stats.lastErrorAt = time.Now()
+}
+
+func (r *rpc) setBest(best int64) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	r.stats.best = best
+}
+
+// 最近一次获取到的节点高度
+func (r *rpc) Best() int64 {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	return r.stats.best
+}
+
+func (r *rpc) initWorkers(n int) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	r.stats.workers = make([]WorkerStatus, n)
+	for i := range r.stats.workers {
+		r.stats.workers[i] = WorkerStatus{Id: i, State: WorkerIdle, Since: time.Now()}
+	}
+}
+
+func (r *rpc) setWorker(id int, state string, height int64, kid string) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	w := &r.stats.workers[id]
+	if w.State != state || w.Height != height || w.Kid != kid {
+		*w = WorkerStatus{Id: id, State: state, Height: height, Kid: kid, Since: time.Now()}
+	}
+}
+
+// 记录已写入的事件数量
+func (r *rpc) countEvents(n int) {
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	now := time.Now()
+	r.stats.samples = append(r.stats.samples, sample{at: now, events: n})
+	r.pruneSamples(now)
+}
+
+func (r *rpc) pruneSamples(now time.Time) {
+	i := 0
+	for i < len(r.stats.samples) && now.Sub(r.stats.samples[i].at) > rateWindow {
+		i++
+	}
+	r.stats.samples = r.stats.samples[i:]
+}
+
+// 当前扫描状态
+func (r *rpc) Status() Status {
+	s := Status{
+		Chain:  r.chain,
+		Cursor: int64(db.FistNumber(r.chain)),
+		Queue:  db.QueueLen(r.chain),
+	}
+	applied, err := db.AppliedNumber(r.chain)
+	if err != nil {
+		r.fail("read applied cursor failed", err)
+	}
+	s.Applied = applied
+	s.Confirmations = conf.Confirmations
+	if v := r.Pending(); v != nil {
+		s.PendingFrom = v.From
+		s.PendingTo = v.To
+	}
+
+	r.stats.mutex.Lock()
+	defer r.stats.mutex.Unlock()
+	s.Best = r.stats.best
+	//落后量按可写入的最高高度计算, 不包含等待确认的区块
+	if target := s.Best - s.Confirmations; target > s.Applied {
+		s.Lag = target - s.Applied
+	}
+	if r.stats.lastError != "" {
+		at := r.stats.lastErrorAt
+		s.LastError = r.stats.lastError
+		s.LastErrorAt = &at
+	}
+	s.Workers = append([]WorkerStatus(nil), r.stats.workers...)
+
+	now := time.Now()
+	r.pruneSamples(now)
+	events := 0
+	for _, smp := range r.stats.samples {
+		events += smp.events
+	}
+	s.EventsPerSecond = float64(events) / rateWindow.Seconds()
+	return s
+}
@@ -0,0 +1,30 @@
+package scanner
+
+import (
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 最多记录的span数量, 追块时拉取远快于写入, 超出后不再关联
+const maxTraces = 10000
+
+// 记录拉取区块的span, 写入时作为父span
+func (r *rpc) saveTrace(height int64, sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.traces) >= maxTraces {
+		return
+	}
+	r.traces[height] = sc
+}
+
+// 取出拉取区块的span, 重启前拉取的区块没有记录
+func (r *rpc) loadTrace(height int64) trace.SpanContext {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	sc := r.traces[height]
+	delete(r.traces, height)
+	return sc
+}
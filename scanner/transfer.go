@@ -1,42 +1,73 @@
 package scanner
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/mitchellh/mapstructure"
-	"holders/conf"
 	"holders/db"
 	"holders/jsonrpc"
 	"holders/models"
-	"log"
+	"log/slog"
 	"strconv"
 )
 
-func transfer(e jsonrpc.Event) {
+// 事件日志, 带上链, 高度, 交易哈希, 合约地址和事件哈希
+func (r *rpc) eventLog(e jsonrpc.Event) *slog.Logger {
+	return slog.With(
+		"chain", r.chain,
+		"height", e.Height,
+		"tx_hash", e.TxHash,
+		"kid", e.KID,
+		"e_hash", e.EHash,
+	)
+}
+
+// 解析单个事件, 返回待写入的转账记录(models.Transfer20 或 models.Transfer721)和合约的kip,
+// 非转账事件和不在索引范围内的事件返回nil, 返回错误时该事件需要重试.
+// 可能在重试和重建时多次调用, 事件计数由调用方在区块写入后统计
+func (r *rpc) transfer(ctx context.Context, e jsonrpc.Event) (interface{}, string, error) {
+	return r.decode(ctx, e, true)
+}
+
+// 解析单个事件, fetchUri为false时不获取B721的tokenUri, 不写入任何缓存,
+// 用于可能被回滚的未确认区块
+func (r *rpc) decode(ctx context.Context, e jsonrpc.Event, fetchUri bool) (interface{}, string, error) {
+	//不在索引范围内的合约
+	if !r.filter.tracks(e.KID, e.Height) {
+		return nil, "", nil
+	}
+	lg := r.eventLog(e)
 	if e.Name == "Transfer" {
-		cli := jsonrpc.GetClient()
+		cli := r.client.WithContext(ctx)
 		param := jsonrpc.ScriptParam{
 			KID: e.KID,
 		}
 		result, err := cli.GetScriptModel(param)
 		if err != nil {
-			log.Println(err)
-			return
+			if errors.Is(err, jsonrpc.ErrNotFind) {
+				lg.Warn("script not found, event skipped", "err", err)
+				return nil, "", nil
+			}
+			return nil, "", err
 		}
 
-		var checkKid string
 		script := result.(*jsonrpc.Script)
+		if !r.filter.tracksKip(script.Kip) {
+			return nil, script.Kip, nil
+		}
 		switch script.Kip {
 		case "B20":
 			sAmount := fmt.Sprint(e.Args["amount"])
 			// 将字符串转换为float64
 			amount, err := strconv.ParseFloat(sAmount, 64)
 			if err != nil {
-				fmt.Println("转换错误:", err)
-				return
+				lg.Warn("invalid amount, event skipped", "amount", sAmount, "err", err)
+				return nil, script.Kip, nil
 			}
-			fmt.Println(e.Args)
 			if e.Args["from"] == nil || e.Args["to"] == nil {
-				return
+				lg.Warn("missing from or to, event skipped")
+				return nil, script.Kip, nil
 			}
 			//记录K20转账
 			t20 := models.Transfer20{
@@ -44,65 +75,80 @@ func transfer(e jsonrpc.Event) {
 				From: e.Args["from"].(string),
 				To: e.Args["to"].(string),
 				Amount: amount,
+				Height: e.Height,
+				TxHash: e.TxHash,
+				EHash:  e.EHash,
 			}
-			err = db.Transaction20(t20)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			checkKid = t20.Kid
+			lg.Debug("transfer resolved", "kip", script.Kip, "from", t20.From, "to", t20.To, "amount", sAmount)
+			return t20, script.Kip, nil
 		case "B721":
 			//记录K721转账
 			var t721 models.Transfer721
 			err := mapstructure.Decode(e.Args, &t721)
 			if err != nil {
-				fmt.Println(err)
-				break
+				lg.Warn("invalid transfer args, event skipped", "err", err)
+				return nil, script.Kip, nil
 			}
 			t721.Kid = e.KID
+			t721.Height = e.Height
+			t721.TxHash = e.TxHash
+			t721.EHash = e.EHash
 
-			uri, err := getTokenUri(t721.Kid, fmt.Sprint(t721.TokenId))
-			if err != nil {
-				log.Println(err)
+			if fetchUri {
+				uri, err := r.getTokenUri(ctx, t721.Kid, fmt.Sprint(t721.TokenId))
+				if err != nil {
+					lg.Warn("get token uri failed", "token_id", fmt.Sprint(t721.TokenId), "err", err)
+				}
+				t721.Data = uri
 			}
-			t721.Data = uri
 
-			err = db.Transaction721(t721)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			checkKid = t721.Kid
-		}
-
-		//获取代币信息
-		if checkKid != "" {
-			go getTokenMeta(checkKid)
+			lg.Debug("transfer resolved", "kip", script.Kip, "from", t721.From, "to", t721.To, "token_id", fmt.Sprint(t721.TokenId))
+			return t721, script.Kip, nil
 		}
+		return nil, script.Kip, nil
+	}
+	return nil, "", nil
+}
 
+// 在后台获取合约信息, 已保存或正在获取的合约直接跳过
+func (r *rpc) fetchTokenMeta(kid string) {
+	if db.GetTokenExits(r.chain, kid) {
+		return
+	}
+	r.mutex.Lock()
+	if r.metas[kid] {
+		r.mutex.Unlock()
+		return
 	}
+	r.metas[kid] = true
+	r.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			r.mutex.Lock()
+			delete(r.metas, kid)
+			r.mutex.Unlock()
+		}()
+		r.getTokenMeta(kid)
+	}()
 }
 
 // 获取该合约额外信息
-func getTokenMeta(kid string) {
-	exits := db.GetTokenExits(kid)
+func (r *rpc) getTokenMeta(kid string) {
+	exits := db.GetTokenExits(r.chain, kid)
 	if !exits {
 		t2 := models.Token{
 			Kid: kid,
 		}
 
 		//获取对应信息并保存
-		rpc, err := jsonrpc.NewClient(conf.NodeUrl)
-		if err != nil {
-			return
-		}
 		param := jsonrpc.TokenParam{
 			KID: kid,
 		}
 
-		token, err := rpc.GetTokenModel(param)
+		token, err := r.client.GetTokenModel(param)
 		if err != nil {
-			log.Println(err)
+			slog.Warn("get token model failed", "kid", kid, "err", err)
 		}
 
 		if token != nil {
@@ -116,31 +162,27 @@ func getTokenMeta(kid string) {
 			t2.TotalSupply = "Unknown"
 		}
 
-		err = db.Token(t2)
+		err = db.Token(r.chain, t2)
 
 		if err != nil {
-			log.Println(err)
+			slog.Error("save token failed", "kid", kid, "err", err)
 			return
 		}
-		db.PutTokenExits(kid)
+		db.PutTokenExits(r.chain, kid)
 	}
 }
 
-func getTokenUri(kid, tokenId string) (string, error) {
-	exits := db.GetTokenExits(kid)
+func (r *rpc) getTokenUri(ctx context.Context, kid, tokenId string) (string, error) {
+	exits := db.GetTokenExits(r.chain, kid)
 	if !exits {
-		db.PutTokenUriExits(kid, tokenId)
+		db.PutTokenUriExits(r.chain, kid, tokenId)
 		//获取对应信息并保存
-		rpc, err := jsonrpc.NewClient(conf.NodeUrl)
-		if err != nil {
-			return "Unknown", err
-		}
 		param := jsonrpc.TokenUriParam{
 			KID:     kid,
 			TokenId: tokenId,
 		}
 
-		uri, err := rpc.GetTokenUri(param)
+		uri, err := r.client.WithContext(ctx).GetTokenUri(param)
 		if err != nil {
 			return "Unknown", err
 		}
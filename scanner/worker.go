@@ -0,0 +1,284 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"hash/fnv"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"holders/metrics"
+	"holders/models"
+	"holders/tracing"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 分配给某个worker的单个区块事件
+type job struct {
+	ctx    context.Context
+	height int64
+	events []indexedEvent
+}
+
+type indexedEvent struct {
+	index int
+	event jsonrpc.Event
+}
+
+// 解析完成的转账, index为事件在区块中的序号
+type prepared struct {
+	index    int
+	transfer interface{}
+}
+
+// 事件计数的标签
+type eventLabel struct {
+	kip  string
+	name string
+}
+
+// 等待写入的区块
+type block struct {
+	ctx       context.Context
+	span      trace.Span
+	height    int64
+	data      []byte
+	events    int
+	transfers []prepared
+	// 按kip和事件名称统计的事件数, 区块写入后计入指标
+	labels map[eventLabel]int
+}
+
+// 按合约地址分片, 保证同一合约的事件顺序
+func shardOf(kid string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(kid))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// 记录每个区块尚未完成的分片数, 区块按顺序交给提交协程
+type progress struct {
+	mutex      sync.Mutex
+	order      []int64
+	pending    map[int64]int
+	blocks     map[int64]*block
+	committing int
+	ready      chan *block
+	freed      chan struct{}
+}
+
+func newProgress() *progress {
+	return &progress{
+		pending: make(map[int64]int),
+		blocks:  make(map[int64]*block),
+		ready:   make(chan *block, conf.ResolveWindow),
+		freed:   make(chan struct{}, 1),
+	}
+}
+
+// 登记一个已分发的区块
+func (p *progress) add(b *block, shards int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.order = append(p.order, b.height)
+	p.pending[b.height] = shards
+	p.blocks[b.height] = b
+	p.flush()
+}
+
+// 某个分片完成了该区块的解析
+func (p *progress) done(height int64, transfers []prepared, labels map[eventLabel]int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	b := p.blocks[height]
+	b.transfers = append(b.transfers, transfers...)
+	if b.labels == nil {
+		b.labels = make(map[eventLabel]int)
+	}
+	for label, n := range labels {
+		b.labels[label] += n
+	}
+	p.pending[height]--
+	p.flush()
+}
+
+// 区块已提交
+func (p *progress) committed() {
+	p.mutex.Lock()
+	p.committing--
+	p.mutex.Unlock()
+
+	select {
+	case p.freed <- struct{}{}:
+	default:
+	}
+}
+
+// 处理中的区块数量
+func (p *progress) outstanding() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.order) + p.committing
+}
+
+// 按顺序将所有分片都已完成的区块交给提交协程
+func (p *progress) flush() {
+	for len(p.order) > 0 {
+		height := p.order[0]
+		if p.pending[height] > 0 {
+			return
+		}
+		b := p.blocks[height]
+		delete(p.pending, height)
+		delete(p.blocks, height)
+		p.order = p.order[1:]
+		p.committing++
+
+		sort.Slice(b.transfers, func(i, j int) bool {
+			return b.transfers[i].index < b.transfers[j].index
+		})
+		p.ready <- b
+	}
+}
+
+// 按顺序解析分配到的事件, 节点请求失败时重试直到成功.
+// ctx取消后放弃未解析完的区块, 这些区块仍保留在队列中
+func (r *rpc) worker(ctx context.Context, id int, jobs <-chan job, p *progress) {
+	for j := range jobs {
+		if ctx.Err() != nil {
+			continue
+		}
+		var transfers []prepared
+		labels := make(map[eventLabel]int)
+		for _, ie := range j.events {
+			r.setWorker(id, WorkerResolving, j.height, ie.event.KID)
+			for ctx.Err() == nil {
+				t, kip, err := r.transfer(j.ctx, ie.event)
+				if err == nil {
+					if t != nil {
+						transfers = append(transfers, prepared{index: ie.index, transfer: t})
+					}
+					labels[eventLabel{kip: kip, name: ie.event.Name}]++
+					break
+				}
+				r.fail("resolve event failed", err,
+					"height", ie.event.Height, "tx_hash", ie.event.TxHash, "kid", ie.event.KID, "e_hash", ie.event.EHash)
+				r.setWorker(id, WorkerRetrying, j.height, ie.event.KID)
+				sleep(ctx, queueRetryInterval)
+			}
+		}
+		r.setWorker(id, WorkerIdle, 0, "")
+		if ctx.Err() != nil {
+			continue
+		}
+		p.done(j.height, transfers, labels)
+	}
+}
+
+// 按高度顺序将区块写入数据库, 失败时按FailPolicy重试或隔离.
+// ctx取消后继续写入已就绪的区块, 但不再重试失败的区块
+func (r *rpc) commit(ctx context.Context, p *progress) {
+	for b := range p.ready {
+		transfers := make([]interface{}, 0, len(b.transfers))
+		for _, t := range b.transfers {
+			transfers = append(transfers, t.transfer)
+		}
+
+		for attempt := 1; ; attempt++ {
+			err := r.applyBlock(b.ctx, b.height, transfers)
+			if err == nil {
+				break
+			}
+			r.fail("apply block failed", err, "height", b.height, "attempt", attempt)
+			b.span.AddEvent("apply failed", trace.WithAttributes(attribute.Int("attempt", attempt)))
+			if ctx.Err() != nil {
+				tracing.End(b.span, err)
+				return
+			}
+
+			if conf.FailPolicy == conf.FailQuarantine && attempt >= conf.MaxRetries {
+				err = r.quarantine(b, err)
+				if err == nil {
+					transfers = nil
+					break
+				}
+				r.fail("quarantine block failed", err, "height", b.height)
+			}
+			sleep(ctx, queueRetryInterval)
+		}
+
+		r.countEvents(b.events)
+		for label, n := range b.labels {
+			metrics.Events.WithLabelValues(label.kip, label.name).Add(float64(n))
+		}
+		b.span.End()
+		err := db.AckQueue(r.chain, b.height)
+		if err != nil {
+			r.fail("ack queue failed", err, "height", b.height)
+		}
+
+		kids := make(map[string]bool)
+		for _, t := range transfers {
+			kids[transferKid(t)] = true
+		}
+		for kid := range kids {
+			//记录合约首次出现的高度, 单合约重建时从该高度开始
+			err = db.PutFirstSeen(r.chain, kid, b.height)
+			if err != nil {
+				r.fail("save first seen height failed", err, "kid", kid, "height", b.height)
+			}
+			//获取代币信息
+			r.fetchTokenMeta(kid)
+		}
+
+		p.committed()
+	}
+}
+
+// 写入区块, 跳过正在重建的合约, 这些合约的事件由重建任务写入
+func (r *rpc) applyBlock(ctx context.Context, height int64, transfers []interface{}) error {
+	r.commitMutex.Lock()
+	defer r.commitMutex.Unlock()
+
+	var filtered []interface{}
+	for _, t := range transfers {
+		if r.reindexing(transferKid(t)) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return db.ApplyBlock(ctx, r.chain, height, filtered)
+}
+
+// 转账记录所属的合约
+func transferKid(t interface{}) string {
+	switch transfer := t.(type) {
+	case models.Transfer20:
+		return transfer.Kid
+	case models.Transfer721:
+		return transfer.Kid
+	}
+	return ""
+}
+
+// 等待d或ctx取消
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// 将区块移入隔离区, 并只推进区块游标
+func (r *rpc) quarantine(b *block, cause error) error {
+	err := db.Quarantine(r.chain, b.height, b.data, fmt.Sprint(cause))
+	if err != nil {
+		return err
+	}
+	b.span.AddEvent("quarantined")
+	return db.ApplyBlock(b.ctx, r.chain, b.height, nil)
+}
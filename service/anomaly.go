@@ -0,0 +1,31 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"holders/db"
+	"holders/models"
+	"net/http"
+	"strconv"
+)
+
+// 查询链上的转账异常, 可按kid和owner过滤, limit默认100, 最大1000
+func getAnomalies(c *gin.Context) {
+	var result models.Result
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+
+	anomalies, err := db.FindAnomalies(chainOf(c), c.Query("kid"), c.Query("owner"), limit)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = anomalies
+	c.JSON(http.StatusOK, result)
+}
@@ -1,15 +1,29 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"holders/metrics"
 	"holders/models"
+	"holders/tracing"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type GinService struct {
 	Service *gin.Engine
+	server  *http.Server
+	mutex   sync.Mutex
 }
 
 // 创建一个Gin服务
@@ -31,13 +45,27 @@ func NewGinService() *GinService {
 	// 应用CORS中间件到所有路由
 	service.Use(cors.New(config))
 
-	return &GinService{service}
+	// 按路由记录请求耗时
+	service.Use(metricsMiddleware())
+	// 每个请求一个span, 并延续调用方传入的trace
+	service.Use(tracingMiddleware())
+
+	return &GinService{Service: service}
 }
 
-// 注入API路由
+// 注入API路由, /assets 查询默认链, /chains/:chain/assets 查询指定的链
 func (g *GinService) loadGroupAPI() *gin.RouterGroup {
 	group := g.Service.Group("/assets")
+	assetRoutes(group)
+
+	//正在处理的链
+	g.Service.GET("/chains", getChains)
+	assetRoutes(g.Service.Group("/chains/:chain/assets", chainMiddleware()))
+
+	return group
+}
 
+func assetRoutes(group *gin.RouterGroup) {
 	//需要鉴权的接口
 	//group.Use(authMiddleware())
 
@@ -50,18 +78,72 @@ func (g *GinService) loadGroupAPI() *gin.RouterGroup {
 		group.GET("/token/:kid", getToken)
 		//批量获取代币信息
 		group.POST("/token/batch",getTokenForBatch)
-		//获取钱包持有数据
+		//获取钱包持有数据, state=latest时叠加未确认区块
 		group.GET("/wallet/:owner", getWalletHolds)
-		//获取持有的TokenId 列表
+		//获取持有的TokenId 列表, state=latest时叠加未确认区块
 		group.GET("/tokenIds", getTokenIds)
 		//获取代币持有分布
 		group.GET("/dist/20/:kid", getDist20)
 		//获取NFT持有分布
 		group.GET("/dist/721/:kid", getDist721)
+		//重建单个代币的持有数据
+		//查看重建进度
+		group.GET("/reindex/:kid", getReindex)
+		group.GET("/reindex", getReindexList)
+		//与链上余额对账
+		group.GET("/reconcile/:kid", reconcileToken)
+		//转账异常
+		group.GET("/anomalies", getAnomalies)
+		//地址的余额变更流水
+		group.GET("/journal/:owner", getJournal)
 	}
+}
 
+// 注入运行状态路由
+func (g *GinService) loadStatusAPI() {
+	//扫描进度
+	g.Service.GET("/status", getStatus)
+	g.Service.GET("/chains/:chain/status", chainMiddleware(), getStatus)
+	//存活检查
+	g.Service.GET("/healthz", healthz)
+	//就绪检查
+	g.Service.GET("/readyz", readyz)
+	//Prometheus指标
+	g.Service.GET("/metrics", gin.WrapH(promhttp.Handler()))
+}
 
-	return group
+// 按路由记录请求耗时, 未匹配的路由统一记为unmatched
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPDuration.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route,
+			attribute.String("http.method", c.Request.Method), attribute.String("http.route", route))
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
 }
 
 //用户验证
@@ -84,12 +166,39 @@ func handleError(c *gin.Context, err any) {
 }
 
 /*
-* 功能介绍: 启动接口服务
+* 功能介绍: 启动接口服务, 调用Shutdown后返回nil
 * @receiver g
 * @param port
  */
-func (g *GinService) Run(port string) {
+func (g *GinService) Run(port string) error {
 	g.loadGroupAPI()
+	g.loadStatusAPI()
+	server := &http.Server{
+		Addr:    port,
+		Handler: g.Service,
+	}
+	g.mutex.Lock()
+	g.server = server
+	g.mutex.Unlock()
 	//启动接口服务
-	g.Service.Run(port)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+/*
+* 功能介绍: 停止接收新请求, 等待处理中的请求完成或ctx到期
+* @receiver g
+* @param ctx
+ */
+func (g *GinService) Shutdown(ctx context.Context) error {
+	g.mutex.Lock()
+	server := g.server
+	g.mutex.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
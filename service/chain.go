@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"holders/conf"
+	"holders/models"
+	"net/http"
+)
+
+// 解析路径中的chain参数, 可以是配置名称或链标识, 只接受正在处理的链
+func chainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("chain")
+		chain := name
+		if p := conf.GetProfile(name); p != nil {
+			chain = p.Chain
+		}
+		for _, active := range conf.ActiveChains() {
+			if active == chain {
+				c.Set("chain", chain)
+				c.Next()
+				return
+			}
+		}
+		var result models.Result
+		result.Code = http.StatusNotFound
+		result.Msg = fmt.Sprintf("unknown chain %q", name)
+		c.AbortWithStatusJSON(result.Code, result)
+	}
+}
+
+// 请求的链, 路径中没有chain参数时为默认链
+func chainOf(c *gin.Context) string {
+	if chain := c.GetString("chain"); chain != "" {
+		return chain
+	}
+	return conf.Chain
+}
+
+// 正在处理的链及其配置
+func getChains(c *gin.Context) {
+	var result models.Result
+	var profiles []*conf.Profile
+	for _, chain := range conf.ActiveChains() {
+		profiles = append(profiles, conf.ProfileOf(chain))
+	}
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = profiles
+	c.JSON(http.StatusOK, result)
+}
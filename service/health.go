@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"holders/conf"
+	"holders/db"
+	"holders/jsonrpc"
+	"holders/models"
+	"holders/scanner"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 健康检查请求节点高度的超时时间, 避免节点无响应时探针一直挂起
+const nodeTimeout = 5 * time.Second
+
+// 进程存活且数据库可用. 只提供接口服务的进程不打开LevelDB, 报告为未配置
+func healthz(c *gin.Context) {
+	var result models.Result
+	err := db.Ping()
+	if err != nil {
+		unavailable(c, err)
+		return
+	}
+	leveldb := "ok"
+	if db.LDB == nil {
+		leveldb = "not configured"
+	}
+	result.Code = http.StatusOK
+	result.Msg = "ok"
+	result.Data = map[string]string{"mysql": "ok", "leveldb": leveldb}
+	c.JSON(http.StatusOK, result)
+}
+
+// 数据库可用且每条链的已写入高度落后 节点高度-确认数 不超过conf.ReadyMaxLag
+func readyz(c *gin.Context) {
+	var result models.Result
+	err := db.Ping()
+	if err != nil {
+		unavailable(c, err)
+		return
+	}
+
+	data := make(map[string]map[string]int64)
+	var behind []string
+	for _, chain := range conf.ActiveChains() {
+		applied, err := db.AppliedNumber(chain)
+		if err != nil {
+			unavailable(c, err)
+			return
+		}
+		best, err := bestBlockNumber(c.Request.Context(), chain)
+		if err != nil {
+			unavailable(c, fmt.Errorf("%s: %w", chain, err))
+			return
+		}
+
+		lag := best - conf.Confirmations - applied
+		if lag < 0 {
+			lag = 0
+		}
+		data[chain] = map[string]int64{"applied": applied, "best": best, "confirmations": conf.Confirmations, "lag": lag}
+		if lag > conf.ReadyMaxLag {
+			behind = append(behind, fmt.Sprintf("%s is %d blocks behind", chain, lag))
+		}
+	}
+	if len(behind) > 0 {
+		result.Code = http.StatusServiceUnavailable
+		result.Msg = strings.Join(behind, "; ")
+		result.Data = data
+		c.JSON(result.Code, result)
+		return
+	}
+	result.Code = http.StatusOK
+	result.Msg = "ok"
+	result.Data = data
+	c.JSON(http.StatusOK, result)
+}
+
+// 链上节点最新高度, 同进程扫描时使用扫描记录的高度, 否则请求节点
+func bestBlockNumber(ctx context.Context, chain string) (int64, error) {
+	if client := scanner.GetClient(chain); client != nil {
+		if best := client.Best(); best > 0 {
+			return best, nil
+		}
+	}
+	cli, err := jsonrpc.NewClient(conf.ProfileOf(chain).NodeUrl)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, nodeTimeout)
+	defer cancel()
+	number, err := cli.WithContext(ctx).BestBlockNumber()
+	if err != nil {
+		return 0, err
+	}
+	return number.(int64), nil
+}
+
+func unavailable(c *gin.Context, err error) {
+	var result models.Result
+	result.Code = http.StatusServiceUnavailable
+	result.Msg = fmt.Sprint(err)
+	c.JSON(result.Code, result)
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"holders/conf"
 	"holders/jsonrpc"
@@ -21,7 +22,7 @@ func ordCall(c *gin.Context)  {
 		return
 	}
 
-	result, err := call(param)
+	result, err := call(c.Request.Context(), chainOf(c), param)
 	if err != nil {
 		handleError(c, err)
 		return
@@ -30,11 +31,11 @@ func ordCall(c *gin.Context)  {
 	c.JSON(http.StatusOK,result)
 }
 
-func call(param jsonrpc.CallParam) (any, error) {
-	cli, err := jsonrpc.NewClient(conf.NodeUrl)
+func call(ctx context.Context, chain string, param jsonrpc.CallParam) (any, error) {
+	cli, err := jsonrpc.NewClient(conf.ProfileOf(chain).NodeUrl)
 	if err != nil {
 		return nil, err
 	}
-	return cli.CallContract(param)
+	return cli.WithContext(ctx).CallContract(param)
 }
 
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"holders/db"
+	"holders/models"
+	"net/http"
+	"strconv"
+)
+
+// 地址的余额变更流水, 可按kid过滤, 按id倒序分页: before为上一页最后一条的id, limit默认100, 最大1000
+func getJournal(c *gin.Context) {
+	var result models.Result
+	owner := c.Param("owner")
+	if owner == "" {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+	before, err := strconv.ParseInt(c.DefaultQuery("before", "0"), 10, 64)
+	if err != nil {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+
+	entries, err := db.FindJournal(chainOf(c), owner, c.Query("kid"), before, limit)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = entries
+	c.JSON(http.StatusOK, result)
+}
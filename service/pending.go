@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"holders/conf"
+	"holders/db"
+	"holders/models"
+	"holders/scanner"
+	"strconv"
+)
+
+// 查询状态
+const (
+	// 只包含达到确认数的区块
+	stateConfirmed = "confirmed"
+	// 叠加未确认区块
+	stateLatest = "latest"
+)
+
+// 响应实际使用的状态, 未确认视图尚未就绪时state=latest返回已确认的数据, 此时为confirmed
+const stateHeader = "X-State"
+
+// 按state参数返回需要叠加的未确认视图, confirmed或没有等待确认的区块时返回nil.
+// 新区块写入后视图需要重建, 重建完成前返回nil并在响应头中标记为confirmed
+func pendingView(c *gin.Context) (*scanner.PendingView, error) {
+	state := c.DefaultQuery("state", stateConfirmed)
+	switch state {
+	case stateConfirmed:
+		return nil, nil
+	case stateLatest:
+	default:
+		return nil, fmt.Errorf("invalid state %q", state)
+	}
+	if conf.Confirmations == 0 {
+		c.Header(stateHeader, stateLatest)
+		return nil, nil
+	}
+
+	client := scanner.GetClient(chainOf(c))
+	if client == nil || !conf.Pending {
+		return nil, errors.New("pending view is not enabled")
+	}
+	c.Header(stateHeader, stateConfirmed)
+	v := client.Pending()
+	if v == nil {
+		return nil, nil
+	}
+	//视图需从当前已写入的高度开始, 否则会与数据库中的数据重复或遗漏
+	applied, err := db.AppliedNumber(chainOf(c))
+	if err != nil {
+		return nil, err
+	}
+	if v.From != applied {
+		return nil, nil
+	}
+	c.Header(stateHeader, stateLatest)
+	return v, nil
+}
+
+// 在已确认的持有数据上叠加未确认的转账
+func overlayWalletHold(chain, owner string, holds map[string]interface{}, v *scanner.PendingView) error {
+	hold20s, _ := holds["t20"].([]models.Hold)
+	hold721s, _ := holds["t721"].([]models.Hold)
+
+	for kid, bip := range v.Kids(owner) {
+		switch bip {
+		case 20:
+			i := findHold(hold20s, kid)
+			var amount float64
+			if i >= 0 {
+				amount, _ = strconv.ParseFloat(hold20s[i].Amount, 64)
+			}
+			amount += v.B20[kid][owner]
+			var err error
+			hold20s, err = setHold(chain, hold20s, i, kid, amount)
+			if err != nil {
+				return err
+			}
+		case 721:
+			tokenIds, err := db.FindTokenIds(chain, kid, owner)
+			if err != nil {
+				return err
+			}
+			tokenIds = overlayTokenIds(kid, owner, tokenIds, v)
+			hold721s, err = setHold(chain, hold721s, findHold(hold721s, kid), kid, float64(len(tokenIds)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	holds["t20"] = hold20s
+	holds["t721"] = hold721s
+	return nil
+}
+
+func findHold(holds []models.Hold, kid string) int {
+	for i, h := range holds {
+		if h.Kid == kid {
+			return i
+		}
+	}
+	return -1
+}
+
+// 更新第i个持有记录的数量, i<0时新增, 数量不大于0时删除
+func setHold(chain string, holds []models.Hold, i int, kid string, amount float64) ([]models.Hold, error) {
+	if amount <= 0 {
+		if i >= 0 {
+			holds = append(holds[:i], holds[i+1:]...)
+		}
+		return holds, nil
+	}
+	if i < 0 {
+		token, err := db.FindToken(chain, kid)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, models.Hold{Kid: kid, Name: token.Name, Symbol: token.Symbol})
+		i = len(holds) - 1
+	}
+	holds[i].Amount = strconv.FormatFloat(amount, 'f', -1, 64)
+	return holds, nil
+}
+
+// 在已确认的tokenId列表上叠加未确认的转移
+func overlayTokenIds(kid, owner string, tokenIds []models.TokenIds, v *scanner.PendingView) []models.TokenIds {
+	moved := v.B721[kid]
+	if len(moved) == 0 {
+		return tokenIds
+	}
+
+	var result []models.TokenIds
+	held := make(map[string]bool)
+	for _, t := range tokenIds {
+		if m, ok := moved[t.TokenId]; ok && m.To != owner {
+			continue
+		}
+		held[t.TokenId] = true
+		result = append(result, t)
+	}
+	for tokenId, m := range moved {
+		if m.To == owner && !held[tokenId] {
+			result = append(result, models.TokenIds{TokenId: tokenId, Data: m.Data})
+		}
+	}
+	return result
+}
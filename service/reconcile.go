@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"holders/models"
+	"holders/scanner"
+	"net/http"
+	"strconv"
+)
+
+// 接口单次对账的最大抽样数量, 每个持有人需要一次节点请求
+const maxReconcileSample = 200
+
+// 与链上余额对账, 参数sample为抽样数量, 默认100, 最大200. 全部检查只能通过reconcile命令执行
+func reconcileToken(c *gin.Context) {
+	var result models.Result
+	kid := c.Param("kid")
+	if kid == "" {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+	sample, err := strconv.Atoi(c.DefaultQuery("sample", "100"))
+	if err != nil || sample <= 0 || sample > maxReconcileSample {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+
+	report, err := scanner.Reconcile(chainOf(c), kid, sample)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = report
+	c.JSON(http.StatusOK, result)
+}
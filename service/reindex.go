@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"holders/models"
+	"holders/scanner"
+	"net/http"
+)
+
+// 重建任务的进度, 重建通过命令行 reindex -kid 触发
+func getReindex(c *gin.Context) {
+	var result models.Result
+	kid := c.Param("kid")
+	if kid == "" {
+		handleError(c, errors.New("invalid params"))
+		return
+	}
+
+	client := scanner.GetClient(chainOf(c))
+	if client == nil {
+		handleError(c, errors.New("scanner is not running"))
+		return
+	}
+	st, ok := client.ReindexStatus(kid)
+	if !ok {
+		handleError(c, errors.New("reindex not found"))
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = st
+	c.JSON(http.StatusOK, result)
+}
+
+func getReindexList(c *gin.Context) {
+	var result models.Result
+	client := scanner.GetClient(chainOf(c))
+	if client == nil {
+		handleError(c, errors.New("scanner is not running"))
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = client.ReindexList()
+	c.JSON(http.StatusOK, result)
+}
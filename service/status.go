@@ -0,0 +1,24 @@
+package api
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"holders/models"
+	"holders/scanner"
+	"net/http"
+)
+
+// 扫描进度, 只有扫描与接口服务在同一进程时可用
+func getStatus(c *gin.Context) {
+	var result models.Result
+	client := scanner.GetClient(chainOf(c))
+	if client == nil {
+		handleError(c, errors.New("scanner is not running"))
+		return
+	}
+
+	result.Code = http.StatusOK
+	result.Msg = "success"
+	result.Data = client.Status()
+	c.JSON(http.StatusOK, result)
+}
@@ -16,7 +16,7 @@ func getToken(c *gin.Context) {
 		return
 	}
 
-	token, err := db.FindToken(kid)
+	token, err := db.FindToken(chainOf(c), kid)
 	if err != nil {
 		handleError(c, err)
 		return
@@ -43,7 +43,7 @@ func getTokenForBatch(c *gin.Context)  {
 	tMap := make(map[string]models.Token)
 
 	for _, kid := range kids.KIDS {
-		token, err := db.FindToken(kid)
+		token, err := db.FindToken(chainOf(c), kid)
 		if err != nil {
 			continue
 		}
@@ -64,11 +64,23 @@ func getWalletHolds(c *gin.Context) {
 		handleError(c, errors.New("invalid params"))
 		return
 	}
-	holds, err := db.FindWalletHold(owner)
+	view, err := pendingView(c)
 	if err != nil {
 		handleError(c, err)
 		return
 	}
+	holds, err := db.FindWalletHold(chainOf(c), owner)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	if view != nil {
+		err = overlayWalletHold(chainOf(c), owner, holds, view)
+		if err != nil {
+			handleError(c, err)
+			return
+		}
+	}
 	result.Code = http.StatusOK
 	result.Msg = "success"
 	result.Data = holds
@@ -84,11 +96,19 @@ func getTokenIds(c *gin.Context) {
 		handleError(c, errors.New("invalid params"))
 		return
 	}
-	tokenIds, err := db.FindTokenIds(kid, owner)
+	view, err := pendingView(c)
+	if err != nil {
+		handleError(c, err)
+		return
+	}
+	tokenIds, err := db.FindTokenIds(chainOf(c), kid, owner)
 	if err != nil {
 		handleError(c, err)
 		return
 	}
+	if view != nil {
+		tokenIds = overlayTokenIds(kid, owner, tokenIds, view)
+	}
 	result.Code = http.StatusOK
 	result.Msg = "success"
 	result.Data = tokenIds
@@ -102,7 +122,7 @@ func getDist20(c *gin.Context) {
 		handleError(c, errors.New("invalid params"))
 		return
 	}
-	dist, err := db.FindDist(kid, true)
+	dist, err := db.FindDist(chainOf(c), kid, true)
 	if err != nil {
 		handleError(c, err)
 		return
@@ -120,7 +140,7 @@ func getDist721(c *gin.Context) {
 		handleError(c, errors.New("invalid params"))
 		return
 	}
-	dist, err := db.FindDist(kid, false)
+	dist, err := db.FindDist(chainOf(c), kid, false)
 	if err != nil {
 		handleError(c, err)
 		return
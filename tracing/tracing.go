@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// 导出方式
+const (
+	// 不导出
+	ExporterNone = ""
+	// 输出到标准输出
+	ExporterStdout = "stdout"
+	// 输出到文件
+	ExporterFile = "file"
+	// 通过OTLP/HTTP导出, 地址未指定时使用OTEL_EXPORTER_OTLP_ENDPOINT
+	ExporterOTLP = "otlp"
+)
+
+const name = "holders"
+
+// 全局tracer, 未调用Setup时为空实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(name)
+}
+
+// 开始一个span
+func Start(ctx context.Context, spanName string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, spanName, trace.WithAttributes(attrs...))
+}
+
+// 结束span, err不为空时标记为错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 配置全局TracerProvider, 返回的函数在退出时调用以导出剩余的span.
+// target为文件路径或OTLP地址
+func Setup(ctx context.Context, exporter, target string) (func(context.Context) error, error) {
+	var (
+		exp    sdktrace.SpanExporter
+		closer io.Closer
+		err    error
+	)
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exp, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		closer = f
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if target != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(target))
+		}
+		exp, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(name))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			closer.Close()
+		}
+		return err
+	}, nil
+}